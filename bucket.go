@@ -20,10 +20,15 @@ type Bucket struct {
 	// Refill is the interval at which Limit tokens are added back to
 	// the bucket, with a maximum of Burst tokens.
 	Refill time.Duration
+	// Clock provides the current time for the non-At methods.
+	// RealClock is used if Clock is nil.
+	Clock Clock
 
 	tokens     int64
 	mux        sync.RWMutex
 	lastUpdate time.Time
+	lastAccess time.Time
+	waiters    []chan struct{}
 }
 
 // NewBucket creates a new Bucket.
@@ -36,9 +41,17 @@ func NewBucket(limit, burst int64, refill time.Duration) *Bucket {
 	}
 }
 
+// Config returns the parameters of the bucket. Unlike reading the
+// fields directly, it is safe while the bucket is being reconfigured.
+func (b *Bucket) Config() Config {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.state().Config
+}
+
 // CanDraw returns whether there are enough tokens remaining in the bucket to draw n.
 func (b *Bucket) CanDraw(n int64) bool {
-	return b.CanDrawAt(time.Now(), n)
+	return b.CanDrawAt(b.now(), n)
 }
 
 // CanDrawAt returns whether there are enough tokens remaining in the bucket to draw n.
@@ -53,7 +66,7 @@ func (b *Bucket) CanDrawAt(t time.Time, n int64) bool {
 // Draw draws n tokens from the bucket, returning whether there were enough tokens
 // remaining to draw without overdraft. If not, no tokens are drawn from the bucket.
 func (b *Bucket) Draw(n int64) bool {
-	return b.DrawAt(time.Now(), n)
+	return b.DrawAt(b.now(), n)
 }
 
 // DrawAt draws n tokens from the bucket, returning whether there were enough tokens
@@ -75,7 +88,7 @@ func (b *Bucket) DrawAt(t time.Time, n int64) bool {
 
 // DrawMax attempts to draw up to n tokens, returning the number of tokens drawn.
 func (b *Bucket) DrawMax(n int64) int64 {
-	return b.DrawMaxAt(time.Now(), n)
+	return b.DrawMaxAt(b.now(), n)
 }
 
 // DrawMaxAt attempts to draw up to n tokens, returning the number of tokens drawn.
@@ -96,7 +109,7 @@ func (b *Bucket) DrawMaxAt(t time.Time, n int64) int64 {
 // a large overdraft will result in a periodic absence of tokens.
 // for potentially multiple refill intervals.
 func (b *Bucket) ForceDraw(n int64) int64 {
-	return b.ForceDrawAt(time.Now(), n)
+	return b.ForceDrawAt(b.now(), n)
 }
 
 // ForceDrawAt forcefully draws a certain number of tokens and
@@ -116,7 +129,7 @@ func (b *Bucket) ForceDrawAt(t time.Time, n int64) int64 {
 
 // SetTokens sets the number of available tokens and sets the last update time to the current time.
 func (b *Bucket) SetTokens(tokens int64) {
-	b.SetTokensAt(time.Now(), tokens)
+	b.SetTokensAt(b.now(), tokens)
 }
 
 // SetTokensAt sets the number of available tokens and sets the last update time to the provided time.
@@ -132,7 +145,7 @@ func (b *Bucket) SetTokensAt(t time.Time, tokens int64) {
 //
 // If the number of tokens in the bucket is less than zero, this returns 0.
 func (b *Bucket) Remaining() int64 {
-	return b.RemainingAt(time.Now())
+	return b.RemainingAt(b.now())
 }
 
 // RemainingAt returns the remaining tokens which can be drawn at the specified time.
@@ -150,7 +163,7 @@ func (b *Bucket) RemainingAt(t time.Time) int64 {
 //
 // May be negative if tokens were overdrafted using SetTokens or ForceDraw.
 func (b *Bucket) Tokens() int64 {
-	return b.TokensAt(time.Now())
+	return b.TokensAt(b.now())
 }
 
 // TokensAt returns the number of tokens in the bucket at the specified time.
@@ -173,6 +186,11 @@ func (b *Bucket) InferTokensAt(t time.Time) int64 {
 	b.mux.RLock()
 	defer b.mux.RUnlock()
 
+	// a bucket created with an invalid refill interval never refills.
+	if b.Refill <= 0 {
+		return b.tokens
+	}
+
 	// determine how many times the refill interval will occur since the last update.
 	delta := int64(t.Sub(b.lastUpdate) / b.Refill)
 
@@ -186,13 +204,13 @@ func (b *Bucket) InferTokensAt(t time.Time) int64 {
 
 // NextRefill returns the next time this bucket will refill.
 func (b *Bucket) NextRefill() time.Time {
-	return b.NextRefillAt(time.Now())
+	return b.NextRefillAt(b.now())
 }
 
 // NextRefillAt returns the next time this bucket will refill, after the specified time.
 func (b *Bucket) NextRefillAt(t time.Time) time.Time {
-	b.mux.RLock()
-	defer b.mux.RUnlock()
+	b.mux.Lock()
+	defer b.mux.Unlock()
 	b.refill(t)
 
 	return nextAfter(b.lastUpdate, t, b.Refill)
@@ -201,7 +219,7 @@ func (b *Bucket) NextRefillAt(t time.Time) time.Time {
 // Reset resets this bucket. The number of tokens available is reset to
 // the burst quantity, and the last update time is set to the current time.
 func (b *Bucket) Reset() {
-	b.ResetAt(time.Now())
+	b.ResetAt(b.now())
 }
 
 // ResetAt resets this bucket. The number of tokens available is reset to
@@ -212,28 +230,38 @@ func (b *Bucket) ResetAt(t time.Time) {
 
 	b.tokens = b.Burst
 	b.lastUpdate = t
+	b.lastAccess = t
 }
 
 // IsReset returns whether this bucket has just been created or is reset to
 // a point where it can be fully drawn from up to the burst quantity.
 func (b *Bucket) IsReset() bool {
-	return b.IsResetAt(time.Now())
+	return b.IsResetAt(b.now())
 }
 
 // IsResetAt returns whether this bucket has just been created or is reset to
 // a point where it can be fully drawn from up to the burst quantity.
 func (b *Bucket) IsResetAt(t time.Time) bool {
-	b.mux.RLock()
-	defer b.mux.RUnlock()
+	b.mux.Lock()
+	defer b.mux.Unlock()
 	b.refill(t)
 
 	return b.tokens == b.Burst
 }
 
+// now returns the current time from the bucket's clock.
+func (b *Bucket) now() time.Time {
+	return clockOrReal(b.Clock).Now()
+}
+
 // refill the tokens based on the last time it was updated and the current time.
 //
 // the bucket must be write-locked for the duration of the call.
 func (b *Bucket) refill(t time.Time) {
+	// every operation on the bucket refills it first, so this is
+	// also the time that the bucket was last used, for idle expiry.
+	b.lastAccess = t
+
 	// if the bucket is already in a state where it is reset, change the lastUpdate time
 	// to the current time to keep it in line with requests. this means a subsequent
 	// request's refills will happen at the correct times, instead of being too early.
@@ -258,6 +286,17 @@ func (b *Bucket) refill(t time.Time) {
 	}
 }
 
+// fullAt refills the bucket at the specified time, without marking it as used,
+// and returns whether it is full.
+//
+// the bucket must be write-locked for the duration of the call.
+func (b *Bucket) fullAt(t time.Time) bool {
+	lastAccess := b.lastAccess
+	b.refill(t)
+	b.lastAccess = lastAccess
+	return b.tokens == b.Burst
+}
+
 // adds diff*refill to the lastUpdate (as opposed to just setting the lastUpdate
 // to the current time. this ensures it always stays in line with the refill interval).
 //
@@ -266,3 +305,89 @@ func (b *Bucket) skipDiff(diff int64) {
 	mod := time.Duration(diff) * b.Refill
 	b.lastUpdate = b.lastUpdate.Add(mod)
 }
+
+// apply refills the bucket at the specified time and then performs op
+// with n, returning the state of the bucket after op has been applied.
+//
+// This is the single atomic operation used by Store implementations
+// which hold Bucket instances in memory.
+func (b *Bucket) apply(t time.Time, op Op, n int64) Result {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	res := Result{OK: true}
+	switch op {
+	case OpCheck:
+		b.refill(t)
+		res.OK = b.tokens >= n
+	case OpDraw:
+		b.refill(t)
+		res.OK = b.tokens >= n
+		if res.OK {
+			b.tokens -= n
+			res.Drawn = n
+		}
+	case OpDrawMax:
+		b.refill(t)
+		res.Drawn = min(n, b.tokens)
+		b.tokens -= res.Drawn
+	case OpForceDraw:
+		b.refill(t)
+		b.tokens -= n
+		res.Drawn = n
+	case OpSet:
+		b.refill(t)
+		b.tokens = n
+	case OpReset:
+		b.tokens = b.Burst
+		b.lastUpdate = t
+		b.lastAccess = t
+	case OpReserve:
+		b.refill(t)
+		res.OK = n <= b.Burst
+		if res.OK {
+			b.tokens -= n
+			res.Drawn = n
+		}
+	case OpRefund:
+		b.refill(t)
+		b.tokens += n
+		if b.tokens >= b.Burst {
+			b.tokens = b.Burst
+			b.lastUpdate = t
+		}
+	}
+
+	res.State = b.state()
+	return res
+}
+
+// state returns the state of the bucket.
+//
+// the bucket must be locked for the duration of the call.
+func (b *Bucket) state() State {
+	return State{
+		Config: Config{
+			Limit:  b.Limit,
+			Burst:  b.Burst,
+			Refill: b.Refill,
+		},
+		Tokens:     b.tokens,
+		LastUpdate: b.lastUpdate,
+	}
+}
+
+// newBucketFromState creates a new Bucket from a previously saved state,
+// using the clock. The bucket is treated as last used at the state's last
+// update time.
+func newBucketFromState(s State, clock Clock) *Bucket {
+	return &Bucket{
+		Clock:      clock,
+		Limit:      s.Limit,
+		Burst:      s.Burst,
+		Refill:     s.Refill,
+		tokens:     s.Tokens,
+		lastUpdate: s.LastUpdate,
+		lastAccess: s.LastUpdate,
+	}
+}
@@ -18,59 +18,155 @@ func TestNewBucket(t *testing.T) {
 	}
 }
 
+func TestBucket_Config(t *testing.T) {
+	b := NewBucket(10, 25, time.Second)
+	if cfg := b.Config(); cfg != (Config{Limit: 10, Burst: 25, Refill: time.Second}) {
+		t.Error("expected config to match the bucket, got", cfg)
+	}
+
+	// reading the config must not race with reconfiguring the bucket
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.Reconfigure(Config{Limit: 5, Burst: 20, Refill: time.Minute}, KeepTokens)
+	}()
+	b.Config()
+	<-done
+
+	if cfg := b.Config(); cfg != (Config{Limit: 5, Burst: 20, Refill: time.Minute}) {
+		t.Error("expected config to be reconfigured, got", cfg)
+	}
+}
+
+// testClock is a Clock whose time only changes when it is advanced.
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) NewTimer(d time.Duration) Timer {
+	return RealClock.NewTimer(d)
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// keyLimiter is the Limiter for a single key of a KeyedLimiter.
+type keyLimiter struct {
+	kl KeyedLimiter
+	id string
+}
+
+func (l keyLimiter) Allow() bool                      { return l.kl.Allow(l.id) }
+func (l keyLimiter) AllowN(n int64) bool              { return l.kl.AllowN(l.id, n) }
+func (l keyLimiter) Remaining() int64                 { return l.kl.Remaining(l.id) }
+func (l keyLimiter) RetryAfter(n int64) time.Duration { return l.kl.RetryAfter(l.id, n) }
+
+// newTimingLimiters returns a bucket with the provided parameters, and the
+// same bucket behind each KeyedLimiter, all using a new test clock.
+func newTimingLimiters(limit, burst int64, refill time.Duration) (map[string]Limiter, *testClock) {
+	clock := &testClock{now: time.Now()}
+
+	b := NewBucket(limit, burst, refill)
+	b.Clock = clock
+	bm := New(limit, burst, refill)
+	bm.Clock = clock
+	m := NewManager(func(string) *Bucket {
+		b := NewBucket(limit, burst, refill)
+		b.Clock = clock
+		return b
+	})
+
+	return map[string]Limiter{
+		"Bucket":        b,
+		"BucketManager": keyLimiter{bm, id},
+		"Manager":       keyLimiter{m, id},
+	}, clock
+}
+
 func TestBucketBasicTimings(t *testing.T) {
-	tu1 := time.Now()
-	tu2 := tu1.Add(time.Second)
-	tu3 := tu2.Add(time.Second)
-	b := NewBucket(5, 20, time.Second)
+	limiters, clock := newTimingLimiters(5, 20, time.Second)
 
 	// tu1: have=20 (init at burst cap)
-	if !b.DrawAt(tu1, 15) {
-		t.Error("expected to be able to draw 15 tokens at time unit 1")
+	for name, l := range limiters {
+		if !l.AllowN(15) {
+			t.Errorf("%s: expected to be able to draw 15 tokens at time unit 1", name)
+		}
 	}
 	// tu2: have=10 (5 from tu1, +5 from refill)
-	if !b.DrawAt(tu2, 5) {
-		t.Error("expected to be able to draw remaining 5 tokens at time unit 2 (have 10)")
+	clock.Advance(time.Second)
+	for name, l := range limiters {
+		if !l.AllowN(5) {
+			t.Errorf("%s: expected to be able to draw remaining 5 tokens at time unit 2 (have 10)", name)
+		}
 	}
 	// tu3: have=10 (5 from tu2, +5 from refill)
-	if b.DrawAt(tu3, 11) {
-		t.Error("expected to NOT be able to draw 11 tokens at time unit 2 (have 10)")
-	}
-	// tu3: have=10 (same as before)
-	if !b.DrawAt(tu3, 10) {
-		t.Error("expected to be able to draw 10 tokens at time unit 2 (have 10)")
+	clock.Advance(time.Second)
+	for name, l := range limiters {
+		if l.AllowN(11) {
+			t.Errorf("%s: expected to NOT be able to draw 11 tokens at time unit 3 (have 10)", name)
+		}
+		// tu3: have=10 (same as before)
+		if !l.AllowN(10) {
+			t.Errorf("%s: expected to be able to draw 10 tokens at time unit 3 (have 10)", name)
+		}
 	}
 }
 
 func TestBucketComplexTimings(t *testing.T) {
-	tu1 := time.Now()
-	tu2 := tu1.Add(time.Second)
-	tu3 := tu2.Add(time.Second)
-	tu4 := tu3.Add(time.Second)
-	tu5 := tu4.Add(time.Second)
-	tu6 := tu5.Add(time.Second)
-	b := NewBucket(5, 20, time.Second)
+	limiters, clock := newTimingLimiters(5, 20, time.Second)
 
 	// tu1: have=20 (init at burst cap)
-	if !b.DrawAt(tu1, 20) {
-		t.Error("expected to be able to draw 15 tokens at time unit 1")
+	for name, l := range limiters {
+		if !l.AllowN(20) {
+			t.Errorf("%s: expected to be able to draw 20 tokens at time unit 1", name)
+		}
 	}
 	// skip tu2 (+5)
 	// skip tu3 (+5)
 	// tu4: have=15 (0 from tu1, +15 from refills including tu4)
-	if !b.CanDrawAt(tu4, 15) {
-		t.Error("expected to be able to draw 15 tokens at time unit 5")
-	}
-	if b.CanDrawAt(tu4, 20) {
-		t.Error("expected to NOT be able to draw 20 tokens at time unit 5")
+	clock.Advance(3 * time.Second)
+	for name, l := range limiters {
+		if remain := l.Remaining(); remain != 15 {
+			t.Errorf("%s: expected to have 15 tokens at time unit 4, got %d", name, remain)
+		}
 	}
 	// skip tu5 (+5)
 	// tu6: have=20 (15 from tu4, +10 from refills, capped at 20)
-	if !b.CanDrawAt(tu6, 20) {
-		t.Error("expected to be able to draw 20 tokens at time unit 6")
+	clock.Advance(2 * time.Second)
+	for name, l := range limiters {
+		if remain := l.Remaining(); remain != 20 {
+			t.Errorf("%s: expected to have 20 tokens at time unit 6, got %d", name, remain)
+		}
+		if l.AllowN(21) {
+			t.Errorf("%s: expected to NOT be able to draw 21 tokens at time unit 6", name)
+		}
 	}
-	if b.CanDrawAt(tu6, 21) {
-		t.Error("expected to NOT be able to draw 21 tokens at time unit 6")
+}
+
+func TestBucket_RetryAfter(t *testing.T) {
+	limiters, clock := newTimingLimiters(5, 20, time.Second)
+
+	for name, l := range limiters {
+		if d := l.RetryAfter(20); d != 0 {
+			t.Errorf("%s: expected no wait for tokens which are available, got %s", name, d)
+		}
+		l.AllowN(20)
+	}
+	clock.Advance(500 * time.Millisecond)
+
+	// have=0, need 2 refills for 7 tokens, the first of which is in 500ms
+	for name, l := range limiters {
+		if d := l.RetryAfter(7); d != 1500*time.Millisecond {
+			t.Errorf("%s: expected to wait %s for 7 tokens, got %s", name, 1500*time.Millisecond, d)
+		}
+		if d := l.RetryAfter(21); d != InfDuration {
+			t.Errorf("%s: expected InfDuration for more tokens than the burst, got %s", name, d)
+		}
 	}
 }
 
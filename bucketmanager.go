@@ -1,6 +1,7 @@
 package gorl
 
 import (
+	"context"
 	"sync"
 	"time"
 )
@@ -11,54 +12,149 @@ import (
 //
 // Buckets are not automatically removed when they no longer contain
 // useful information (when they have fully refilled), but you can call
-// Purge
+// Purge or Sweep, or use StartJanitor to remove them periodically.
+//
+// The buckets are held by a Store, which is a MemoryStore unless the
+// manager is created using NewWithStore. If the store returns an error,
+// it is passed to ErrorHandler and the operation is treated as if the
+// bucket had no tokens, so draws fail closed. The same is done if the
+// parameters for an id are invalid, so buckets are never created from an
+// invalid template; see Config.Validate and NewE.
 type BucketManager struct {
+	// Limit, Burst and Refill are the parameters used to create new buckets.
+	// Use Reconfigure to change them while the manager is in use.
 	Limit  int64
 	Burst  int64
 	Refill time.Duration
 
-	buckets   map[string]*Bucket
-	bucketMux sync.RWMutex
+	// Clock provides the current time for the non-At methods, and is given
+	// to the buckets which the manager creates. RealClock is used if Clock is nil.
+	Clock Clock
+
+	// ErrorHandler is called with any error returned by the store.
+	// Errors are discarded if ErrorHandler is nil.
+	ErrorHandler func(id string, err error)
+
+	// IdleTTL is how long a bucket may go unused before Sweep removes it,
+	// even if it is not fully refilled. Zero disables idle expiry.
+	IdleTTL time.Duration
+	// OnSweep is called with the number of buckets removed by each Sweep.
+	OnSweep func(evicted int)
+
+	// Resolver returns the parameters for the bucket with the id when it is
+	// created, so that different keys may have different limits, such as for
+	// each pricing tier. The manager's Limit, Burst and Refill are used if
+	// Resolver is nil. It is called for every operation, so it should be fast.
+	// Use Migrate to apply a new config to an existing bucket.
+	Resolver func(id string) Config
+
+	// Quotas holds calendar-window quotas which are enforced alongside the
+	// buckets, such as a monthly allowance on top of a per-second rate.
+	// When Quotas is set, CanDraw, Draw, DrawMax, ForceDraw, Remaining and
+	// RetryAfter consider both the bucket and the quota for the same id, and
+	// requests are only counted against the quota if the bucket allows them.
+	// Wait and Reserve only consider the bucket.
+	Quotas *Manager[*Quota]
+
+	store     Store
+	configMux sync.RWMutex
 }
 
 func New(limit, burst int64, refill time.Duration) *BucketManager {
+	return NewWithStore(limit, burst, refill, NewMemoryStore())
+}
+
+// NewWithStore creates a new BucketManager whose buckets are held by the provided store.
+func NewWithStore(limit, burst int64, refill time.Duration, store Store) *BucketManager {
 	return &BucketManager{
-		Limit:   limit,
-		Burst:   burst,
-		Refill:  refill,
-		buckets: make(map[string]*Bucket),
+		Limit:  limit,
+		Burst:  burst,
+		Refill: refill,
+		store:  store,
+	}
+}
+
+// Store returns the store which holds the buckets of this BucketManager.
+func (m *BucketManager) Store() Store {
+	return m.store
+}
+
+// Config returns the parameters used to create the bucket with the id, which
+// are resolved using Resolver if it is set, or the manager's parameters if not.
+func (m *BucketManager) Config(id string) Config {
+	if m.Resolver != nil {
+		return m.Resolver(id)
+	}
+
+	m.configMux.RLock()
+	defer m.configMux.RUnlock()
+	return Config{
+		Limit:  m.Limit,
+		Burst:  m.Burst,
+		Refill: m.Refill,
+	}
+}
+
+// TierResolver returns a Resolver which gives each id the config of its named
+// tier, such as "free" or "pro", as returned by tierOf. Ids whose tier is not
+// in tiers are given the fallback config.
+func TierResolver(tiers map[string]Config, fallback Config, tierOf func(id string) string) func(id string) Config {
+	return func(id string) Config {
+		if cfg, ok := tiers[tierOf(id)]; ok {
+			return cfg
+		}
+		return fallback
 	}
 }
 
 // Get gets a bucket from the BucketManager, creating it if necessary.
+//
+// If the store does not hold Bucket instances in memory, the returned bucket
+// is a detached copy of the bucket's current state, and changes made to it
+// are not saved unless it is passed to Set.
 func (m *BucketManager) Get(id string) *Bucket {
-	return m.getOrCreate(id)
+	cfg := m.Config(id)
+	if s, ok := m.store.(bucketStore); ok && cfg.Validate() == nil {
+		return s.bucket(id, cfg, m.Clock)
+	}
+	return newBucketFromState(m.apply(id, m.now(), OpCheck, 0).State, m.Clock)
 }
 
 // Set adds a bucket to the BucketManager.
 func (m *BucketManager) Set(id string, bucket *Bucket) {
-	m.set(id, bucket)
+	if s, ok := m.store.(bucketStore); ok {
+		s.setBucket(id, bucket)
+		return
+	}
+
+	bucket.mux.RLock()
+	state := bucket.state()
+	bucket.mux.RUnlock()
+	m.handleError(id, m.store.Save(id, state))
 }
 
 // Delete removes a bucket from the BucketManager.
 func (m *BucketManager) Delete(id string) {
-	m.delete(id)
+	m.handleError(id, m.store.Delete(id))
 }
 
 // CanDraw returns whether there are enough tokens remaining in the bucket to draw n.
 func (m *BucketManager) CanDraw(id string, n int64) bool {
-	return m.getOrCreate(id).CanDraw(n)
+	return m.CanDrawAt(id, m.now(), n)
 }
 
 // CanDrawAt returns whether there are enough tokens remaining in the bucket to draw n.
 func (m *BucketManager) CanDrawAt(id string, t time.Time, n int64) bool {
-	return m.getOrCreate(id).CanDrawAt(t, n)
+	if m.Quotas != nil && !m.Quotas.Get(id).CanDrawAt(t, n) {
+		return false
+	}
+	return m.apply(id, t, OpCheck, n).OK
 }
 
 // Draw draws n tokens from the bucket, returning whether there were enough tokens
 // remaining to draw without overdraft. If not, no tokens are drawn from the bucket.
 func (m *BucketManager) Draw(id string, n int64) bool {
-	return m.getOrCreate(id).Draw(n)
+	return m.DrawAt(id, m.now(), n)
 }
 
 // DrawAt draws n tokens from the bucket, returning whether there were enough tokens
@@ -67,17 +163,90 @@ func (m *BucketManager) Draw(id string, n int64) bool {
 // The number of tokens in the bucket increases as expected, so
 // a large overdraft will result in a periodic absence of tokens.
 func (m *BucketManager) DrawAt(id string, t time.Time, n int64) bool {
-	return m.getOrCreate(id).DrawAt(t, n)
+	if m.Quotas != nil {
+		return m.Quotas.Get(id).drawWith(t, n, func() bool {
+			return m.apply(id, t, OpDraw, n).OK
+		})
+	}
+	return m.apply(id, t, OpDraw, n).OK
+}
+
+// Wait blocks until n tokens can be drawn from the bucket and draws them,
+// or returns ctx.Err() if the context is done first.
+//
+// Waiters on the same bucket are served in the order that they called Wait,
+// unless the store does not hold Bucket instances in memory, in which case
+// waiters poll the store at each refill and the order is not guaranteed.
+func (m *BucketManager) Wait(ctx context.Context, id string, n int64) error {
+	cfg := m.Config(id)
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if s, ok := m.store.(bucketStore); ok {
+		return s.bucket(id, cfg, m.Clock).Wait(ctx, n)
+	}
+	return waitDraw(ctx, clockOrReal(m.Clock), n, func(t time.Time) (Result, error) {
+		return m.store.Apply(id, cfg, t, OpDraw, n)
+	})
+}
+
+// Reserve reserves n tokens from the bucket, returning a Reservation
+// which reports how long the caller must wait before acting on them.
+func (m *BucketManager) Reserve(id string, n int64) *Reservation {
+	return m.ReserveAt(id, m.now(), n)
+}
+
+// ReserveAt reserves n tokens from the bucket at the specified time, returning
+// a Reservation which reports how long the caller must wait before acting on them.
+func (m *BucketManager) ReserveAt(id string, t time.Time, n int64) *Reservation {
+	res := m.apply(id, t, OpReserve, n)
+	return newReservation(res, t, m.Clock, func(t time.Time, n int64) {
+		m.apply(id, t, OpRefund, n)
+	})
+}
+
+// Allow draws 1 token from the bucket, returning whether there was one available.
+func (m *BucketManager) Allow(id string) bool {
+	return m.Draw(id, 1)
+}
+
+// AllowN draws n tokens from the bucket, returning whether there were enough
+// tokens remaining to draw without overdraft. It is the same as Draw.
+func (m *BucketManager) AllowN(id string, n int64) bool {
+	return m.Draw(id, n)
+}
+
+// RetryAfter returns how long until n tokens can be drawn from the bucket.
+func (m *BucketManager) RetryAfter(id string, n int64) time.Duration {
+	return m.RetryAfterAt(id, m.now(), n)
+}
+
+// RetryAfterAt returns how long after the specified time until n tokens can be drawn
+// from the bucket, which is zero if they can be drawn now, or InfDuration if n
+// exceeds the burst quantity.
+func (m *BucketManager) RetryAfterAt(id string, t time.Time, n int64) time.Duration {
+	d := timeUntil(m.apply(id, t, OpCheck, 0).State, t, n)
+	if m.Quotas != nil {
+		if qd := m.Quotas.Get(id).RetryAfterAt(t, n); qd > d {
+			return qd
+		}
+	}
+	return d
 }
 
 // DrawMax attempts to draw up to n tokens, returning the number of tokens drawn.
 func (m *BucketManager) DrawMax(id string, n int64) int64 {
-	return m.getOrCreate(id).DrawMax(n)
+	return m.DrawMaxAt(id, m.now(), n)
 }
 
 // DrawMaxAt attempts to draw up to n tokens, returning the number of tokens drawn.
 func (m *BucketManager) DrawMaxAt(id string, t time.Time, n int64) int64 {
-	return m.getOrCreate(id).DrawMaxAt(t, n)
+	if m.Quotas != nil {
+		return m.Quotas.Get(id).drawMaxWith(t, n, func(n int64) int64 {
+			return m.apply(id, t, OpDrawMax, n).Drawn
+		})
+	}
+	return m.apply(id, t, OpDrawMax, n).Drawn
 }
 
 // ForceDraw forcefully draws a certain number of tokens and
@@ -87,7 +256,7 @@ func (m *BucketManager) DrawMaxAt(id string, t time.Time, n int64) int64 {
 // a large overdraft will result in a periodic absence of tokens.
 // for potentially multiple refill intervals.
 func (m *BucketManager) ForceDraw(id string, n int64) int64 {
-	return m.getOrCreate(id).ForceDraw(n)
+	return m.ForceDrawAt(id, m.now(), n)
 }
 
 // ForceDrawAt forcefully draws a certain number of tokens and
@@ -97,45 +266,57 @@ func (m *BucketManager) ForceDraw(id string, n int64) int64 {
 // a large overdraft will result in a periodic absence of tokens
 // for potentially multiple refill intervals.
 func (m *BucketManager) ForceDrawAt(id string, t time.Time, n int64) int64 {
-	return m.getOrCreate(id).ForceDrawAt(t, n)
+	if m.Quotas != nil {
+		m.Quotas.Get(id).ForceDrawAt(t, n)
+	}
+	return m.apply(id, t, OpForceDraw, n).Tokens
 }
 
 // SetTokens sets the number of available tokens and sets the last update time to the current time.
 func (m *BucketManager) SetTokens(id string, tokens int64) {
-	m.getOrCreate(id).SetTokens(tokens)
+	m.SetTokensAt(id, m.now(), tokens)
 }
 
 // SetTokensAt sets the number of available tokens and sets the last update time to the provided time.
 func (m *BucketManager) SetTokensAt(id string, t time.Time, tokens int64) {
-	m.getOrCreate(id).SetTokensAt(t, tokens)
+	m.apply(id, t, OpSet, tokens)
 }
 
 // Remaining returns the remaining tokens which can be drawn.
 //
 // If the number of tokens in the bucket is less than zero, this returns 0.
 func (m *BucketManager) Remaining(id string) int64 {
-	return m.getOrCreate(id).Remaining()
+	return m.RemainingAt(id, m.now())
 }
 
 // RemainingAt returns the remaining tokens which can be drawn at the specified time.
 //
 // If the number of tokens in the bucket is less than zero, this returns 0.
 func (m *BucketManager) RemainingAt(id string, t time.Time) int64 {
-	return m.getOrCreate(id).RemainingAt(t)
+	tokens := m.TokensAt(id, t)
+	if m.Quotas != nil {
+		if remaining := m.Quotas.Get(id).RemainingAt(t); remaining < tokens {
+			tokens = remaining
+		}
+	}
+	if tokens < 0 {
+		return 0
+	}
+	return tokens
 }
 
 // Tokens returns the number of tokens in the bucket.
 //
 // May be negative if tokens were overdrafted using SetTokens or ForceDraw.
 func (m *BucketManager) Tokens(id string) int64 {
-	return m.getOrCreate(id).TokensAt(time.Now())
+	return m.TokensAt(id, m.now())
 }
 
 // TokensAt returns the number of tokens in the bucket at the specified time.
 //
 // May be negative if tokens were overdrafted using SetTokens or ForceDraw.
 func (m *BucketManager) TokensAt(id string, t time.Time) int64 {
-	return m.getOrCreate(id).TokensAt(t)
+	return m.apply(id, t, OpCheck, 0).Tokens
 }
 
 // InferTokensAt returns the number of tokens that will be in the bucket at the
@@ -144,45 +325,55 @@ func (m *BucketManager) TokensAt(id string, t time.Time) int64 {
 //
 // May be negative if tokens were overdrafted using SetTokens or ForceDraw.
 func (m *BucketManager) InferTokensAt(id string, t time.Time) int64 {
-	return m.getOrCreate(id).InferTokensAt(t)
+	state, ok, err := m.store.Load(id)
+	if err != nil {
+		m.handleError(id, err)
+		return 0
+	}
+	if !ok {
+		return m.Config(id).Burst
+	}
+	return newBucketFromState(state, m.Clock).InferTokensAt(t)
 }
 
 // NextRefill returns the next time this bucket will refill.
 //
 // This method does not modify the bucket, so it may be called with times which are out of chronology.
 func (m *BucketManager) NextRefill(id string) time.Time {
-	return m.getOrCreate(id).NextRefillAt(time.Now())
+	return m.NextRefillAt(id, m.now())
 }
 
 // NextRefillAt returns the next time this bucket will refill, after the specified time.
 //
 // This method does not modify the bucket, so it may be called with times which are out of chronology.
 func (m *BucketManager) NextRefillAt(id string, t time.Time) time.Time {
-	return m.getOrCreate(id).NextRefillAt(t)
+	res := m.apply(id, t, OpCheck, 0)
+	return nextAfter(res.LastUpdate, t, res.Refill)
 }
 
 // Reset resets this bucket. The number of tokens available is reset to
 // the burst quantity, and the last update time is set to the current time.
 func (m *BucketManager) Reset(id string) {
-	m.getOrCreate(id).Reset()
+	m.ResetAt(id, m.now())
 }
 
 // ResetAt resets this bucket. The number of tokens available is reset to
 // the burst quantity, and the last update time is set to the provided time.
 func (m *BucketManager) ResetAt(id string, t time.Time) {
-	m.getOrCreate(id).ResetAt(t)
+	m.apply(id, t, OpReset, 0)
 }
 
 // IsReset returns whether this bucket has just been created or is reset to
 // a point where it can be fully drawn from up to the burst quantity.
 func (m *BucketManager) IsReset(id string) bool {
-	return m.getOrCreate(id).IsReset()
+	return m.IsResetAt(id, m.now())
 }
 
 // IsResetAt returns whether this bucket has just been created or is reset to
 // a point where it can be fully drawn from up to the burst quantity.
 func (m *BucketManager) IsResetAt(id string, t time.Time) bool {
-	return m.getOrCreate(id).IsResetAt(t)
+	res := m.apply(id, t, OpCheck, 0)
+	return res.Tokens == res.Burst
 }
 
 // Purge removes elements which return true for IsReset, returning
@@ -192,48 +383,60 @@ func (m *BucketManager) IsResetAt(id string, t time.Time) bool {
 // issues if the buckets are modified between the time that the
 // purge loop starts and the time that they would be removed.
 func (m *BucketManager) Purge() int {
-	removed := 0
-
-	m.bucketMux.RLock()
-	for id, bucket := range m.buckets {
-		if bucket.IsReset() {
-			m.bucketMux.RUnlock()
-			m.bucketMux.Lock()
-			delete(m.buckets, id)
-			m.bucketMux.Unlock()
-			m.bucketMux.RLock()
-		}
-	}
-	m.bucketMux.RUnlock()
-
+	removed, err := m.store.Purge(m.now())
+	m.handleError("", err)
 	return removed
 }
 
-func (m *BucketManager) getOrCreate(id string) *Bucket {
-	if bucket, ok := m.get(id); ok {
-		return bucket
-	}
+// Migrate moves the existing bucket with the id to the parameters which are
+// now returned by Resolver, such as when a customer moves to another tier.
+// The number of tokens is scaled in proportion to the new burst quantity,
+// so a bucket which was half full remains half full.
+func (m *BucketManager) Migrate(id string) {
+	m.MigrateAt(id, m.now())
+}
 
-	bucket := NewBucket(m.Limit, m.Burst, m.Refill)
-	m.set(id, bucket)
-	return bucket
+// MigrateAt moves the existing bucket with the id to the parameters which are
+// now returned by Resolver, at the specified time. The number of tokens is
+// scaled in proportion to the new burst quantity.
+//
+// If the store does not hold Bucket instances in memory, the bucket is
+// loaded and saved again, so draws made in between may be lost.
+func (m *BucketManager) MigrateAt(id string, t time.Time) {
+	m.reconfigureBucket(id, t, m.Config(id), ScaleTokens)
 }
 
-func (m *BucketManager) get(id string) (*Bucket, bool) {
-	m.bucketMux.RLock()
-	bucket, ok := m.buckets[id]
-	m.bucketMux.RUnlock()
-	return bucket, ok
+// now returns the current time from the manager's clock.
+func (m *BucketManager) now() time.Time {
+	return clockOrReal(m.Clock).Now()
 }
 
-func (m *BucketManager) set(id string, bucket *Bucket) {
-	m.bucketMux.Lock()
-	m.buckets[id] = bucket
-	m.bucketMux.Unlock()
+// apply performs op on the bucket through the store. If the config for the
+// id is invalid or the store fails, the error is handled and an empty,
+// unsuccessful result is returned, so no bucket is created.
+//
+// Stores which hold live buckets are bypassed, so that buckets
+// created by the manager are given the manager's clock.
+func (m *BucketManager) apply(id string, t time.Time, op Op, n int64) Result {
+	cfg := m.Config(id)
+	if err := cfg.Validate(); err != nil {
+		m.handleError(id, err)
+		return Result{State: State{Config: cfg, LastUpdate: t}}
+	}
+	if s, ok := m.store.(bucketStore); ok {
+		return s.bucket(id, cfg, m.Clock).apply(t, op, n)
+	}
+
+	res, err := m.store.Apply(id, cfg, t, op, n)
+	if err != nil {
+		m.handleError(id, err)
+		return Result{State: State{Config: cfg, LastUpdate: t}}
+	}
+	return res
 }
 
-func (m *BucketManager) delete(id string) {
-	m.bucketMux.Lock()
-	delete(m.buckets, id)
-	m.bucketMux.Unlock()
+func (m *BucketManager) handleError(id string, err error) {
+	if err != nil && m.ErrorHandler != nil {
+		m.ErrorHandler(id, err)
+	}
 }
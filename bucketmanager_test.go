@@ -160,3 +160,36 @@ func TestBucketManager_Reset(t *testing.T) {
 		t.Errorf("mismatched refill: expected '%d' but got '%d'", time.Second, b.Refill)
 	}
 }
+
+func TestBucketManager_Tiers(t *testing.T) {
+	now := time.Now()
+	tiers := map[string]string{"alice": "pro"}
+	bm := New(1, 10, time.Second)
+	bm.Resolver = TierResolver(map[string]Config{
+		"free": {Limit: 1, Burst: 10, Refill: time.Second},
+		"pro":  {Limit: 10, Burst: 100, Refill: time.Second},
+	}, bm.Config(""), func(id string) string {
+		return tiers[id]
+	})
+
+	if tokens := bm.TokensAt("alice", now); tokens != 100 {
+		t.Error("expected pro bucket to start with 100 tokens, got", tokens)
+	}
+	if tokens := bm.TokensAt("bob", now); tokens != 10 {
+		t.Error("expected fallback bucket to start with 10 tokens, got", tokens)
+	}
+
+	// bob upgrades with 4 of 10 tokens left, so has 40 of 100
+	bm.ForceDrawAt("bob", now, 6)
+	tiers["bob"] = "pro"
+	bm.MigrateAt("bob", now)
+	if tokens := bm.TokensAt("bob", now); tokens != 40 {
+		t.Error("expected migrated bucket to have 40 tokens, got", tokens)
+	}
+	if tokens := bm.TokensAt("bob", now.Add(time.Second)); tokens != 50 {
+		t.Error("expected migrated bucket to refill at the new limit, got", tokens)
+	}
+	if cfg := bm.Config("bob"); cfg.Burst != 100 {
+		t.Error("expected bob to be in the pro tier, got", cfg)
+	}
+}
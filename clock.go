@@ -0,0 +1,57 @@
+package gorl
+
+import "time"
+
+// Clock provides the current time and timers to a Bucket or BucketManager,
+// which allows tests to control time without using the "At" methods.
+// The gorltest package provides a FakeClock for this purpose.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// NewTimer creates a Timer which sends the current time on its
+	// channel after at least the duration d has passed.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a single event created by a Clock, like time.Timer.
+type Timer interface {
+	// C returns the channel on which the time is sent when the timer fires.
+	C() <-chan time.Time
+	// Stop prevents the timer from firing, returning false
+	// if the timer has already fired or been stopped.
+	Stop() bool
+}
+
+// RealClock is a Clock which uses the system time.
+// It is used by buckets and managers which do not have a Clock set.
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+// clockOrReal returns the clock, or RealClock if it is nil.
+func clockOrReal(c Clock) Clock {
+	if c == nil {
+		return RealClock
+	}
+	return c
+}
@@ -0,0 +1,239 @@
+package gorl
+
+import (
+	"math"
+	"time"
+)
+
+// Composite is a thread-safe limiter which enforces several buckets at once,
+// such as 10 per second, 1000 per hour and 10000 per day. Requests are only
+// allowed if every bucket allows them, and are then drawn from all of them
+// atomically, so no bucket is partly drawn from when a request is denied.
+//
+// The buckets are locked in order for each operation, so buckets which are
+// shared with another Composite must be in the same order in both, or
+// concurrent draws may deadlock.
+type Composite struct {
+	// Buckets are the limits which are enforced together.
+	Buckets []*Bucket
+	// Clock provides the current time for the non-At methods.
+	// RealClock is used if Clock is nil.
+	Clock Clock
+}
+
+// NewComposite creates a new Composite which enforces the provided buckets.
+func NewComposite(buckets ...*Bucket) *Composite {
+	return &Composite{
+		Buckets: buckets,
+	}
+}
+
+// NewCompositeFactory returns a factory for NewManager which creates
+// composites with a new bucket for each of the provided configs.
+func NewCompositeFactory(configs ...Config) func(id string) *Composite {
+	return func(string) *Composite {
+		buckets := make([]*Bucket, len(configs))
+		for i, cfg := range configs {
+			buckets[i] = NewBucket(cfg.Limit, cfg.Burst, cfg.Refill)
+		}
+		return NewComposite(buckets...)
+	}
+}
+
+// CanDraw returns whether there are enough tokens remaining in every bucket to draw n.
+func (c *Composite) CanDraw(n int64) bool {
+	return c.CanDrawAt(c.now(), n)
+}
+
+// CanDrawAt returns whether there are enough tokens remaining in every bucket to draw n.
+func (c *Composite) CanDrawAt(t time.Time, n int64) bool {
+	c.lock()
+	defer c.unlock()
+	c.refill(t)
+
+	return c.tokens() >= n
+}
+
+// Draw draws n tokens from every bucket, returning whether there were enough tokens
+// remaining in all of them. If not, no tokens are drawn from any bucket.
+func (c *Composite) Draw(n int64) bool {
+	return c.DrawAt(c.now(), n)
+}
+
+// DrawAt draws n tokens from every bucket, returning whether there were enough tokens
+// remaining in all of them. If not, no tokens are drawn from any bucket.
+func (c *Composite) DrawAt(t time.Time, n int64) bool {
+	c.lock()
+	defer c.unlock()
+	c.refill(t)
+
+	if c.tokens() < n {
+		return false
+	}
+	for _, b := range c.Buckets {
+		b.tokens -= n
+	}
+	return true
+}
+
+// DrawMax attempts to draw up to n tokens from every bucket, returning the number of tokens drawn.
+func (c *Composite) DrawMax(n int64) int64 {
+	return c.DrawMaxAt(c.now(), n)
+}
+
+// DrawMaxAt attempts to draw up to n tokens from every bucket, returning the number
+// of tokens drawn, which is limited by the bucket with the fewest tokens.
+func (c *Composite) DrawMaxAt(t time.Time, n int64) int64 {
+	c.lock()
+	defer c.unlock()
+	c.refill(t)
+
+	drawn := min(n, c.tokens())
+	if drawn < 0 {
+		drawn = 0
+	}
+	for _, b := range c.Buckets {
+		b.tokens -= drawn
+	}
+	return drawn
+}
+
+// ForceDraw forcefully draws n tokens from every bucket and returns the
+// number of tokens in the bucket with the fewest, which may be negative.
+func (c *Composite) ForceDraw(n int64) int64 {
+	return c.ForceDrawAt(c.now(), n)
+}
+
+// ForceDrawAt forcefully draws n tokens from every bucket and returns the
+// number of tokens in the bucket with the fewest, which may be negative.
+func (c *Composite) ForceDrawAt(t time.Time, n int64) int64 {
+	c.lock()
+	defer c.unlock()
+	c.refill(t)
+
+	for _, b := range c.Buckets {
+		b.tokens -= n
+	}
+	return c.tokens()
+}
+
+// Remaining returns the number of tokens which can be drawn from every bucket.
+func (c *Composite) Remaining() int64 {
+	return c.RemainingAt(c.now())
+}
+
+// RemainingAt returns the number of tokens which can be drawn from every bucket
+// at the specified time, which is the number remaining in the tightest bucket.
+func (c *Composite) RemainingAt(t time.Time) int64 {
+	c.lock()
+	defer c.unlock()
+	c.refill(t)
+
+	tokens := c.tokens()
+	if tokens < 0 {
+		return 0
+	}
+	return tokens
+}
+
+// Reset resets every bucket.
+func (c *Composite) Reset() {
+	c.ResetAt(c.now())
+}
+
+// ResetAt resets every bucket, setting the last update time to the provided time.
+func (c *Composite) ResetAt(t time.Time) {
+	for _, b := range c.Buckets {
+		b.ResetAt(t)
+	}
+}
+
+// IsReset returns whether every bucket can be fully drawn from up to its burst quantity.
+func (c *Composite) IsReset() bool {
+	return c.IsResetAt(c.now())
+}
+
+// IsResetAt returns whether every bucket can be fully drawn from up to its burst quantity.
+func (c *Composite) IsResetAt(t time.Time) bool {
+	c.lock()
+	defer c.unlock()
+
+	for _, b := range c.Buckets {
+		if !b.fullAt(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// Allow draws 1 token from every bucket, returning whether there was one available in all of them.
+func (c *Composite) Allow() bool {
+	return c.Draw(1)
+}
+
+// AllowN draws n tokens from every bucket, returning whether there were enough
+// tokens remaining in all of them. It is the same as Draw.
+func (c *Composite) AllowN(n int64) bool {
+	return c.Draw(n)
+}
+
+// RetryAfter returns how long until n tokens can be drawn from every bucket.
+func (c *Composite) RetryAfter(n int64) time.Duration {
+	return c.RetryAfterAt(c.now(), n)
+}
+
+// RetryAfterAt returns how long after the specified time until n tokens can be drawn
+// from every bucket, which is the longest wait of any bucket. This is zero if they
+// can be drawn now, or InfDuration if n exceeds the burst quantity of any bucket.
+func (c *Composite) RetryAfterAt(t time.Time, n int64) time.Duration {
+	c.lock()
+	defer c.unlock()
+	c.refill(t)
+
+	var longest time.Duration
+	for _, b := range c.Buckets {
+		if d := timeUntil(b.state(), t, n); d > longest {
+			longest = d
+		}
+	}
+	return longest
+}
+
+// now returns the current time from the limiter's clock.
+func (c *Composite) now() time.Time {
+	return clockOrReal(c.Clock).Now()
+}
+
+// lock write-locks every bucket, in order.
+func (c *Composite) lock() {
+	for _, b := range c.Buckets {
+		b.mux.Lock()
+	}
+}
+
+// unlock unlocks every bucket, in reverse order.
+func (c *Composite) unlock() {
+	for i := len(c.Buckets) - 1; i >= 0; i-- {
+		c.Buckets[i].mux.Unlock()
+	}
+}
+
+// refill refills every bucket at the specified time.
+//
+// the composite must be locked for the duration of the call.
+func (c *Composite) refill(t time.Time) {
+	for _, b := range c.Buckets {
+		b.refill(t)
+	}
+}
+
+// tokens returns the number of tokens in the bucket with the fewest.
+//
+// the composite must be locked for the duration of the call.
+func (c *Composite) tokens() int64 {
+	tokens := int64(math.MaxInt64)
+	for _, b := range c.Buckets {
+		tokens = min(tokens, b.tokens)
+	}
+	return tokens
+}
@@ -0,0 +1,73 @@
+package gorl
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComposite_Draw(t *testing.T) {
+	now := time.Now()
+	c := NewComposite(
+		NewBucket(5, 5, time.Second),
+		NewBucket(8, 8, time.Minute),
+	)
+
+	if !c.DrawAt(now, 5) {
+		t.Error("expected to be able to draw 5 tokens")
+	}
+	now = now.Add(time.Second)
+	if c.DrawAt(now, 4) {
+		t.Error("expected to NOT be able to draw 4 tokens (minute bucket has 3)")
+	}
+	if tokens := c.Buckets[0].TokensAt(now); tokens != 5 {
+		t.Error("expected denied draw to leave the second bucket untouched, got", tokens)
+	}
+	if remaining := c.RemainingAt(now); remaining != 3 {
+		t.Error("expected remaining to come from the tightest bucket (3), got", remaining)
+	}
+	if drawn := c.DrawMaxAt(now, 5); drawn != 3 {
+		t.Error("expected to draw 3 tokens, got", drawn)
+	}
+	if tokens := c.Buckets[0].TokensAt(now); tokens != 2 {
+		t.Error("expected second bucket to have 2 tokens, got", tokens)
+	}
+}
+
+func TestComposite_RetryAfter(t *testing.T) {
+	now := time.Now()
+	c := NewComposite(
+		NewBucket(1, 2, time.Second),
+		NewBucket(2, 2, time.Minute),
+	)
+
+	c.ForceDrawAt(now, 2)
+	if d := c.RetryAfterAt(now, 1); d != time.Minute {
+		t.Error("expected retry after to come from the tightest bucket (1m), got", d)
+	}
+	if d := c.RetryAfterAt(now, 3); d != InfDuration {
+		t.Error("expected retry after to be infinite, got", d)
+	}
+	if c.IsResetAt(now.Add(2 * time.Second)) {
+		t.Error("expected composite to NOT be reset while the minute bucket refills")
+	}
+	if !c.IsResetAt(now.Add(time.Minute)) {
+		t.Error("expected composite to be reset after a minute")
+	}
+}
+
+func TestCompositeFactory(t *testing.T) {
+	m := NewManager(NewCompositeFactory(
+		Config{Limit: 10, Burst: 10, Refill: time.Second},
+		Config{Limit: 15, Burst: 15, Refill: time.Hour},
+	))
+
+	if !m.AllowN(id, 10) {
+		t.Error("expected to be able to draw 10 tokens")
+	}
+	if n := len(m.Get(id).Buckets); n != 2 {
+		t.Error("expected 2 buckets, got", n)
+	}
+	if remaining := m.Get("other").Remaining(); remaining != 10 {
+		t.Error("expected another id to have its own buckets, got", remaining)
+	}
+}
@@ -0,0 +1,192 @@
+package gorl
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrExceedsLimit is returned by Acquire when more slots are requested
+// than the concurrency limit, so the acquisition could never be satisfied.
+var ErrExceedsLimit = errors.New("gorl: requested slots exceed concurrency limit")
+
+// ConcurrencyManager is a thread-safe keyed semaphore, which limits the
+// number of operations in flight for each id, such as "at most 10
+// concurrent requests per tenant". It complements BucketManager, which
+// limits the rate at which operations start.
+//
+// Keys are created when they are first acquired, and are removed as soon
+// as every slot has been released and nobody is waiting, so idle keys do
+// not need to be purged.
+type ConcurrencyManager struct {
+	// Limit is the number of slots which may be held for each id at once.
+	Limit int64
+
+	slots    map[string]*slots
+	slotsMux sync.Mutex
+}
+
+// slots holds the state of a single id.
+type slots struct {
+	held    int64
+	waiters []*slotWaiter
+}
+
+type slotWaiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+// NewConcurrencyManager creates a new ConcurrencyManager which allows limit slots to be held for each id.
+func NewConcurrencyManager(limit int64) *ConcurrencyManager {
+	return &ConcurrencyManager{
+		Limit: limit,
+		slots: make(map[string]*slots),
+	}
+}
+
+// Acquire blocks until n slots can be held for the id and acquires them,
+// returning a function which releases them. If the context is done first,
+// ctx.Err() is returned and no slots are held.
+//
+// Waiters on the same id are served in the order that they called Acquire,
+// so a waiter which requests a large number of slots is not starved by
+// smaller ones. The release function may safely be called more than once.
+func (m *ConcurrencyManager) Acquire(ctx context.Context, id string, n int64) (release func(), err error) {
+	m.slotsMux.Lock()
+	if n > m.Limit {
+		m.slotsMux.Unlock()
+		return nil, ErrExceedsLimit
+	}
+
+	s := m.getOrCreate(id)
+	if len(s.waiters) == 0 && s.held+n <= m.Limit {
+		s.held += n
+		m.slotsMux.Unlock()
+		return m.releaser(id, s, n), nil
+	}
+
+	w := &slotWaiter{n: n, ready: make(chan struct{})}
+	s.waiters = append(s.waiters, w)
+	m.slotsMux.Unlock()
+
+	select {
+	case <-w.ready:
+		return m.releaser(id, s, n), nil
+	case <-ctx.Done():
+	}
+
+	m.slotsMux.Lock()
+	defer m.slotsMux.Unlock()
+	select {
+	case <-w.ready:
+		// the slots were granted after the context was done,
+		// so give them back as if they were never acquired.
+		s.held -= n
+	default:
+		for i, other := range s.waiters {
+			if other == w {
+				s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+				break
+			}
+		}
+	}
+	m.notifyLocked(id, s)
+	return nil, ctx.Err()
+}
+
+// TryAcquire acquires n slots for the id if they are available without
+// waiting, returning a function which releases them and whether they were
+// acquired. The release function is nil if the slots were not acquired.
+func (m *ConcurrencyManager) TryAcquire(id string, n int64) (release func(), ok bool) {
+	m.slotsMux.Lock()
+	defer m.slotsMux.Unlock()
+
+	s := m.getOrCreate(id)
+	if len(s.waiters) > 0 || s.held+n > m.Limit {
+		m.notifyLocked(id, s)
+		return nil, false
+	}
+	s.held += n
+	return m.releaser(id, s, n), true
+}
+
+// InFlight returns the number of slots held for the id.
+func (m *ConcurrencyManager) InFlight(id string) int64 {
+	m.slotsMux.Lock()
+	defer m.slotsMux.Unlock()
+
+	if s, ok := m.slots[id]; ok {
+		return s.held
+	}
+	return 0
+}
+
+// Remaining returns the number of slots which may be acquired for the id.
+//
+// If more slots are held than the limit allows, such as after the
+// limit has been lowered, this returns 0.
+func (m *ConcurrencyManager) Remaining(id string) int64 {
+	remaining := m.Limit - m.InFlight(id)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// Len returns the number of ids which hold slots or have waiters.
+func (m *ConcurrencyManager) Len() int {
+	m.slotsMux.Lock()
+	defer m.slotsMux.Unlock()
+	return len(m.slots)
+}
+
+// getOrCreate returns the slots for the id, creating them if necessary.
+//
+// the manager must be locked for the duration of the call.
+func (m *ConcurrencyManager) getOrCreate(id string) *slots {
+	if m.slots == nil {
+		m.slots = make(map[string]*slots)
+	}
+
+	s, ok := m.slots[id]
+	if !ok {
+		s = &slots{}
+		m.slots[id] = s
+	}
+	return s
+}
+
+// releaser returns a function which releases n slots once.
+func (m *ConcurrencyManager) releaser(id string, s *slots, n int64) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.slotsMux.Lock()
+			defer m.slotsMux.Unlock()
+
+			s.held -= n
+			m.notifyLocked(id, s)
+		})
+	}
+}
+
+// notifyLocked grants slots to waiters in order until the one at the front
+// does not fit, then removes the id if it is no longer in use.
+//
+// the manager must be locked for the duration of the call.
+func (m *ConcurrencyManager) notifyLocked(id string, s *slots) {
+	for len(s.waiters) > 0 {
+		w := s.waiters[0]
+		if s.held+w.n > m.Limit {
+			break
+		}
+		s.held += w.n
+		s.waiters = s.waiters[1:]
+		close(w.ready)
+	}
+
+	if s.held == 0 && len(s.waiters) == 0 && m.slots[id] == s {
+		delete(m.slots, id)
+	}
+}
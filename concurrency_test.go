@@ -0,0 +1,89 @@
+package gorl
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyManager_TryAcquire(t *testing.T) {
+	cm := NewConcurrencyManager(3)
+
+	release, ok := cm.TryAcquire(id, 2)
+	if !ok {
+		t.Fatal("expected to acquire 2 slots")
+	}
+	if _, ok := cm.TryAcquire(id, 2); ok {
+		t.Error("expected to NOT acquire 2 more slots")
+	}
+	if remaining := cm.Remaining(id); remaining != 1 {
+		t.Error("expected 1 remaining slot, got", remaining)
+	}
+	if _, ok := cm.TryAcquire("other", 3); !ok {
+		t.Error("expected another id to have its own slots")
+	}
+
+	release()
+	release()
+	if n := cm.InFlight(id); n != 0 {
+		t.Error("expected no slots in flight after release, got", n)
+	}
+	if n := cm.Len(); n != 1 {
+		t.Error("expected idle id to be removed, got", n)
+	}
+}
+
+func TestConcurrencyManager_Acquire(t *testing.T) {
+	cm := NewConcurrencyManager(2)
+	ctx := context.Background()
+
+	release, err := cm.Acquire(ctx, id, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// waiters are served in order, so the small request waits behind the large one
+	order := make(chan int64, 2)
+	for _, n := range []int64{2, 1} {
+		n := n
+		go func() {
+			release, err := cm.Acquire(ctx, id, n)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			order <- n
+			time.Sleep(10 * time.Millisecond)
+			release()
+		}()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, ok := cm.TryAcquire(id, 1); ok {
+		t.Error("expected TryAcquire to NOT jump the queue")
+	}
+	release()
+	if first, second := <-order, <-order; first != 2 || second != 1 {
+		t.Error("expected waiters to be served in order, got", first, second)
+	}
+
+	if _, err := cm.Acquire(ctx, id, 3); err != ErrExceedsLimit {
+		t.Error("expected ErrExceedsLimit, got", err)
+	}
+}
+
+func TestConcurrencyManager_AcquireCancel(t *testing.T) {
+	cm := NewConcurrencyManager(1)
+	release, _ := cm.TryAcquire(id, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := cm.Acquire(ctx, id, 1); err != context.DeadlineExceeded {
+		t.Error("expected context.DeadlineExceeded, got", err)
+	}
+
+	release()
+	if n := cm.Len(); n != 0 {
+		t.Error("expected idle id to be removed, got", n)
+	}
+}
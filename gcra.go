@@ -0,0 +1,206 @@
+package gorl
+
+import (
+	"sync"
+	"time"
+)
+
+// GCRA is a thread-safe limiter using the generic cell rate algorithm. It takes
+// the same parameters as Bucket, but stores only a single "theoretical arrival
+// time" (TAT), and paces requests smoothly: rather than Limit tokens being added
+// at the end of each Refill interval, one token becomes available every
+// Refill/Limit. Up to Burst requests may still be made at once.
+//
+// Its long-run throughput is the same as a Bucket with the same parameters.
+//
+// Warning: As with Bucket, the times provided to the "At" methods (in order)
+// must not chronologically descend.
+type GCRA struct {
+	// Limit is the number of requests allowed per time unit, Refill.
+	Limit int64
+	// Burst is the number of requests allowed to be made at once.
+	Burst int64
+	// Refill is the time over which Limit requests are allowed.
+	Refill time.Duration
+	// Clock provides the current time for the non-At methods.
+	// RealClock is used if Clock is nil.
+	Clock Clock
+
+	tat time.Time
+	mux sync.Mutex
+}
+
+// NewGCRA creates a new GCRA limiter. Use NewGCRAE to validate the parameters.
+func NewGCRA(limit, burst int64, refill time.Duration) *GCRA {
+	return &GCRA{
+		Limit:  limit,
+		Burst:  burst,
+		Refill: refill,
+	}
+}
+
+// CanDraw returns whether n requests are allowed now.
+func (g *GCRA) CanDraw(n int64) bool {
+	return g.CanDrawAt(g.now(), n)
+}
+
+// CanDrawAt returns whether n requests are allowed at the specified time.
+func (g *GCRA) CanDrawAt(t time.Time, n int64) bool {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	_, ok := g.next(t, n)
+	return ok
+}
+
+// Draw records n requests, returning whether they were allowed. If not, nothing is recorded.
+func (g *GCRA) Draw(n int64) bool {
+	return g.DrawAt(g.now(), n)
+}
+
+// DrawAt records n requests at the specified time, returning whether they
+// were allowed. If not, nothing is recorded.
+func (g *GCRA) DrawAt(t time.Time, n int64) bool {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	tat, ok := g.next(t, n)
+	if ok {
+		g.tat = tat
+	}
+	return ok
+}
+
+// Remaining returns the number of requests allowed now.
+func (g *GCRA) Remaining() int64 {
+	return g.RemainingAt(g.now())
+}
+
+// RemainingAt returns the number of requests allowed at the specified time.
+func (g *GCRA) RemainingAt(t time.Time) int64 {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	return g.remaining(t)
+}
+
+// NextRefill returns the next time that another request will be allowed.
+func (g *GCRA) NextRefill() time.Time {
+	return g.NextRefillAt(g.now())
+}
+
+// NextRefillAt returns the next time after the specified time that another request
+// will be allowed. If the burst quantity is already allowed, this is the specified time.
+func (g *GCRA) NextRefillAt(t time.Time) time.Time {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	remaining := g.remaining(t)
+	if remaining >= g.Burst {
+		return t
+	}
+	return g.tat.Add(-time.Duration(g.Burst-remaining-1) * g.interval())
+}
+
+// Reset resets the limiter, so that the burst quantity is allowed again.
+func (g *GCRA) Reset() {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	g.tat = time.Time{}
+}
+
+// IsReset returns whether the burst quantity is allowed now.
+func (g *GCRA) IsReset() bool {
+	return g.IsResetAt(g.now())
+}
+
+// IsResetAt returns whether the burst quantity is allowed at the specified time.
+func (g *GCRA) IsResetAt(t time.Time) bool {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	return !g.tat.After(t)
+}
+
+// Allow records one request, returning whether it was allowed.
+func (g *GCRA) Allow() bool {
+	return g.Draw(1)
+}
+
+// AllowN records n requests, returning whether they were allowed. It is the same as Draw.
+func (g *GCRA) AllowN(n int64) bool {
+	return g.Draw(n)
+}
+
+// RetryAfter returns how long until n requests will be allowed.
+func (g *GCRA) RetryAfter(n int64) time.Duration {
+	return g.RetryAfterAt(g.now(), n)
+}
+
+// RetryAfterAt returns how long after the specified time until n requests will be
+// allowed, which is zero if they are allowed now, or InfDuration if n exceeds Burst.
+func (g *GCRA) RetryAfterAt(t time.Time, n int64) time.Duration {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	if n > g.Burst {
+		return InfDuration
+	}
+	allowed := g.tat.Add(-time.Duration(g.Burst-n) * g.interval())
+	if !allowed.After(t) {
+		return 0
+	}
+	return allowed.Sub(t)
+}
+
+// now returns the current time from the limiter's clock.
+func (g *GCRA) now() time.Time {
+	return clockOrReal(g.Clock).Now()
+}
+
+// interval returns the emission interval, which is the time between requests
+// at the sustained rate.
+//
+// The interval is at least a nanosecond, even if Refill is shorter than Limit
+// nanoseconds, and a Limit which is not positive is treated as 1, so that an
+// invalid limiter does not divide by zero. See NewGCRAE.
+func (g *GCRA) interval() time.Duration {
+	interval := g.Refill
+	if g.Limit > 0 {
+		interval /= time.Duration(g.Limit)
+	}
+	if interval < 1 {
+		return 1
+	}
+	return interval
+}
+
+// next returns the theoretical arrival time after n requests at the specified
+// time, and whether they are within the burst tolerance.
+//
+// the limiter must be locked for the duration of the call.
+func (g *GCRA) next(t time.Time, n int64) (time.Time, bool) {
+	tat := g.tat
+	if tat.Before(t) {
+		tat = t
+	}
+	tat = tat.Add(time.Duration(n) * g.interval())
+
+	tolerance := time.Duration(g.Burst) * g.interval()
+	return tat, n >= 0 && tat.Sub(t) <= tolerance
+}
+
+// remaining returns the number of requests allowed at the specified time.
+//
+// the limiter must be locked for the duration of the call.
+func (g *GCRA) remaining(t time.Time) int64 {
+	if !g.tat.After(t) {
+		return g.Burst
+	}
+	remaining := g.Burst - int64((g.tat.Sub(t)+g.interval()-1)/g.interval())
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
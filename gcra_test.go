@@ -0,0 +1,100 @@
+package gorl
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGCRA_Burst(t *testing.T) {
+	now := time.Now()
+	g := NewGCRA(5, 20, time.Second)
+
+	if !g.DrawAt(now, 20) {
+		t.Error("expected to be able to draw the burst quantity at once")
+	}
+	if g.CanDrawAt(now, 1) {
+		t.Error("expected to NOT be able to draw after the burst")
+	}
+	if !g.IsResetAt(now.Add(4 * time.Second)) {
+		t.Error("expected the burst to be allowed again after Burst/Limit refills")
+	}
+}
+
+func TestGCRA_Pacing(t *testing.T) {
+	now := time.Now()
+	g := NewGCRA(5, 20, time.Second)
+	g.DrawAt(now, 20)
+
+	// one request becomes available every 200ms, rather than 5 every second
+	if remain := g.RemainingAt(now.Add(199 * time.Millisecond)); remain != 0 {
+		t.Error("expected no remaining requests after 199ms, got", remain)
+	}
+	if remain := g.RemainingAt(now.Add(200 * time.Millisecond)); remain != 1 {
+		t.Error("expected 1 remaining request after 200ms, got", remain)
+	}
+	if remain := g.RemainingAt(now.Add(time.Second)); remain != 5 {
+		t.Error("expected 5 remaining requests after 1s, got", remain)
+	}
+	if next := g.NextRefillAt(now.Add(300 * time.Millisecond)); !next.Equal(now.Add(400 * time.Millisecond)) {
+		t.Error("expected the next request to be allowed at 400ms, got", next.Sub(now))
+	}
+}
+
+func TestGCRA_RetryAfter(t *testing.T) {
+	now := time.Now()
+	g := NewGCRA(5, 20, time.Second)
+	g.DrawAt(now, 20)
+
+	if d := g.RetryAfterAt(now, 3); d != 600*time.Millisecond {
+		t.Errorf("expected to wait %s for 3 requests, got %s", 600*time.Millisecond, d)
+	}
+	if !g.DrawAt(now.Add(600*time.Millisecond), 3) {
+		t.Error("expected to be able to draw 3 after waiting")
+	}
+	if d := g.RetryAfterAt(now, 21); d != InfDuration {
+		t.Error("expected InfDuration for more requests than the burst, got", d)
+	}
+}
+
+func TestGCRA_Throughput(t *testing.T) {
+	// a client which tries to draw every 10ms for 100s should get the same
+	// number of requests through either limiter with the same parameters.
+	now := time.Now()
+	b := NewBucket(5, 10, time.Second)
+	g := NewGCRA(5, 10, time.Second)
+
+	var fromBucket, fromGCRA int
+	for ms := 0; ms < 100000; ms += 10 {
+		at := now.Add(time.Duration(ms) * time.Millisecond)
+		if b.DrawAt(at, 1) {
+			fromBucket++
+		}
+		if g.DrawAt(at, 1) {
+			fromGCRA++
+		}
+	}
+
+	// burst + rate * duration, allowing for where each is within its last interval
+	if fromBucket < 505 || fromBucket > 515 {
+		t.Error("expected the bucket to allow about 510 requests, got", fromBucket)
+	}
+	if diff := fromBucket - fromGCRA; diff < -5 || diff > 5 {
+		t.Errorf("expected the same long-run throughput, got %d from the bucket and %d from gcra", fromBucket, fromGCRA)
+	}
+}
+
+func TestGCRA_Manager(t *testing.T) {
+	m := NewManager(func(string) *GCRA {
+		return NewGCRA(1, 3, time.Hour)
+	})
+
+	if !m.AllowN(id, 3) {
+		t.Error("expected to be able to draw 3")
+	}
+	if m.Allow(id) {
+		t.Error("expected to NOT be able to draw a fourth")
+	}
+	if d := m.RetryAfter(id, 1); d <= 0 || d > time.Hour {
+		t.Error("expected to wait up to an hour for another request, got", d)
+	}
+}
@@ -0,0 +1,132 @@
+// Package gorltest provides utilities for testing code which uses gorl.
+package gorltest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zytekaron/gorl"
+)
+
+// FakeClock is a gorl.Clock whose time only changes when it is advanced,
+// so that buckets, managers and anything blocked on them by Wait or
+// Sleep can be tested deterministically. It is safe for concurrent use.
+type FakeClock struct {
+	mux     sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	pending []*fakeTimer
+}
+
+// NewFakeClock creates a new FakeClock set to the provided time.
+func NewFakeClock(t time.Time) *FakeClock {
+	c := &FakeClock{now: t}
+	c.cond = sync.NewCond(&c.mux)
+	return c
+}
+
+// Now returns the current time of the clock.
+func (c *FakeClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.now
+}
+
+// NewTimer creates a timer which fires once the clock has been advanced by d.
+func (c *FakeClock) NewTimer(d time.Duration) gorl.Timer {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	t := &fakeTimer{
+		clock:    c,
+		c:        make(chan time.Time, 1),
+		deadline: c.now.Add(d),
+	}
+	if d <= 0 {
+		t.c <- c.now
+		return t
+	}
+
+	c.pending = append(c.pending, t)
+	c.cond.Broadcast()
+	return t
+}
+
+// Sleep blocks until the clock has been advanced by d.
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.NewTimer(d).C()
+}
+
+// Advance moves the clock forward by d, firing every timer which is due
+// in order of their deadlines. Each timer receives its own deadline.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves the clock to the provided time, firing every timer which is due.
+// The clock is never moved backwards.
+func (c *FakeClock) Set(t time.Time) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if t.Before(c.now) {
+		return
+	}
+
+	sort.SliceStable(c.pending, func(i, j int) bool {
+		return c.pending[i].deadline.Before(c.pending[j].deadline)
+	})
+	fired := 0
+	for _, timer := range c.pending {
+		if timer.deadline.After(t) {
+			break
+		}
+		c.now = timer.deadline
+		timer.c <- timer.deadline
+		fired++
+	}
+	c.pending = c.pending[fired:]
+	c.now = t
+}
+
+// Waiters returns the number of timers and sleepers waiting for the clock to advance.
+func (c *FakeClock) Waiters() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return len(c.pending)
+}
+
+// BlockUntil blocks until at least n timers or sleepers are waiting for the
+// clock to advance. This lets a test advance the clock only once the code
+// under test, such as a call to Bucket.Wait, has started waiting.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for len(c.pending) < n {
+		c.cond.Wait()
+	}
+}
+
+type fakeTimer struct {
+	clock    *FakeClock
+	c        chan time.Time
+	deadline time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mux.Lock()
+	defer t.clock.mux.Unlock()
+
+	for i, timer := range t.clock.pending {
+		if timer == t {
+			t.clock.pending = append(t.clock.pending[:i], t.clock.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package gorltest
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zytekaron/gorl"
+)
+
+var epoch = time.Unix(1700000000, 0)
+
+func TestFakeClock_Timers(t *testing.T) {
+	c := NewFakeClock(epoch)
+
+	t1 := c.NewTimer(2 * time.Second)
+	t2 := c.NewTimer(time.Second)
+	t3 := c.NewTimer(3 * time.Second)
+	if c.Waiters() != 3 {
+		t.Error("expected 3 waiting timers, got", c.Waiters())
+	}
+
+	c.Advance(2 * time.Second)
+	for i, timer := range []gorl.Timer{t2, t1} {
+		select {
+		case <-timer.C():
+		default:
+			t.Errorf("expected timer %d to have fired", i)
+		}
+	}
+	select {
+	case <-t3.C():
+		t.Error("expected the third timer to not have fired yet")
+	default:
+	}
+
+	if !t3.Stop() {
+		t.Error("expected to stop the pending timer")
+	}
+	if t3.Stop() {
+		t.Error("expected stopping a stopped timer to return false")
+	}
+	if !c.Now().Equal(epoch.Add(2 * time.Second)) {
+		t.Error("expected the clock to have advanced by 2 seconds, got", c.Now())
+	}
+}
+
+func TestFakeClock_Sleep(t *testing.T) {
+	c := NewFakeClock(epoch)
+
+	done := make(chan struct{})
+	go func() {
+		c.Sleep(time.Minute)
+		close(done)
+	}()
+
+	c.BlockUntil(1)
+	c.Advance(time.Minute)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("expected the sleeper to wake once the clock advanced")
+	}
+}
+
+func TestFakeClock_Bucket(t *testing.T) {
+	c := NewFakeClock(epoch)
+	b := gorl.NewBucket(5, 20, time.Second)
+	b.Clock = c
+
+	b.Draw(20)
+	c.Advance(time.Second)
+	if remain := b.Remaining(); remain != 5 {
+		t.Error("expected remaining tokens to be 5 after one refill, got", remain)
+	}
+}
+
+func TestFakeClock_Wait(t *testing.T) {
+	c := NewFakeClock(epoch)
+	bm := gorl.New(5, 5, time.Minute)
+	bm.Clock = c
+	bm.Draw("a", 5)
+
+	done := make(chan error)
+	go func() {
+		done <- bm.Wait(context.Background(), "a", 5)
+	}()
+
+	c.BlockUntil(1)
+	select {
+	case <-done:
+		t.Fatal("expected the waiter to block until the next refill")
+	default:
+	}
+
+	c.Advance(time.Minute)
+	if err := <-done; err != nil {
+		t.Error("expected the wait to succeed after the refill, got", err)
+	}
+	if remain := bm.Remaining("a"); remain != 0 {
+		t.Error("expected the waiter to have drawn the refilled tokens, have", remain)
+	}
+}
+
+func TestFakeClock_Restore(t *testing.T) {
+	c := NewFakeClock(epoch)
+	bm := gorl.New(5, 20, time.Second)
+	bm.Clock = c
+	bm.Draw("a", 20)
+
+	var buf bytes.Buffer
+	if err := bm.Snapshot(&buf); err != nil {
+		t.Fatal("unexpected error taking snapshot:", err)
+	}
+
+	restored := gorl.New(5, 20, time.Second)
+	restored.Clock = c
+	if err := restored.Restore(&buf); err != nil {
+		t.Fatal("unexpected error restoring snapshot:", err)
+	}
+	if b := restored.Get("a"); b.Clock != c {
+		t.Error("expected restored bucket to use the manager's clock")
+	}
+
+	c.Advance(time.Second)
+	if remain := restored.Remaining("a"); remain != 5 {
+		t.Error("expected remaining tokens to be 5 after one refill, got", remain)
+	}
+}
+
+func TestFakeClock_GetFromStore(t *testing.T) {
+	c := NewFakeClock(epoch)
+
+	// hide the MemoryStore's buckets, so that Get creates
+	// a new bucket from the state of the stored one.
+	bm := gorl.NewWithStore(5, 20, time.Second, struct{ gorl.Store }{gorl.NewMemoryStore()})
+	bm.Clock = c
+	bm.Draw("a", 20)
+
+	b := bm.Get("a")
+	if b.Clock != c {
+		t.Error("expected bucket to use the manager's clock")
+	}
+	c.Advance(time.Second)
+	if remain := b.Remaining(); remain != 5 {
+		t.Error("expected remaining tokens to be 5 after one refill, got", remain)
+	}
+}
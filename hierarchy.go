@@ -0,0 +1,272 @@
+package gorl
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is one level of a Hierarchy, such as the limit shared by a whole
+// endpoint, or the limit for each user. Each key at the level has its own
+// bucket with these parameters.
+type Level struct {
+	// Name identifies the level in a HierarchyResult, such as "org" or "user".
+	Name   string
+	Limit  int64
+	Burst  int64
+	Refill time.Duration
+}
+
+// HierarchyResult is the result of a draw from a Hierarchy.
+type HierarchyResult struct {
+	// OK is whether the draw was allowed by every level.
+	OK bool
+	// Denied is the index of the highest level which denied the draw, or -1 if it was allowed.
+	Denied int
+	// DeniedBy is the name of the level which denied the draw, or "" if it was allowed.
+	DeniedBy string
+	// Remaining is the number of tokens which can be drawn after the draw,
+	// which is the number remaining in the tightest bucket.
+	Remaining int64
+	// RetryAfter is how long until the draw can be retried,
+	// which is zero if it was allowed.
+	RetryAfter time.Duration
+}
+
+// Hierarchy is a thread-safe manager for nested limits, such as "each user
+// 100 per minute, but the whole endpoint at most 5000 per minute". Drawing
+// for a key also draws from the bucket of every parent key, all or nothing,
+// so no bucket is drawn from when any level denies the draw.
+//
+// A key is given as a path with one element for each level, starting at the
+// root, such as {"endpoint", "acme", "alice"}. The bucket at each level is
+// identified by the path up to that level, so "alice" in one org does not
+// share a bucket with "alice" in another. A shorter path only draws from the
+// levels it reaches.
+//
+// Buckets are created when they are first queried. They are not removed
+// automatically, but you can call Purge to remove full buckets.
+type Hierarchy struct {
+	// Levels are the limits at each level, starting at the root.
+	Levels []Level
+	// Clock provides the current time for the non-At methods, and is given
+	// to the buckets which the hierarchy creates. RealClock is used if Clock is nil.
+	Clock Clock
+
+	buckets    []map[string]*Bucket
+	bucketsMux sync.RWMutex
+}
+
+// NewHierarchy creates a new Hierarchy with the provided levels, starting at the root.
+func NewHierarchy(levels ...Level) *Hierarchy {
+	return &Hierarchy{
+		Levels: levels,
+	}
+}
+
+// CanDraw returns whether there are enough tokens remaining at every level of the path to draw n.
+func (h *Hierarchy) CanDraw(path []string, n int64) HierarchyResult {
+	return h.CanDrawAt(path, h.now(), n)
+}
+
+// CanDrawAt returns whether there are enough tokens remaining at every level of the path to draw n.
+func (h *Hierarchy) CanDrawAt(path []string, t time.Time, n int64) HierarchyResult {
+	buckets := h.lock(path)
+	defer unlockAll(buckets)
+
+	return h.check(buckets, t, n)
+}
+
+// Draw draws n tokens from the bucket at every level of the path, returning whether
+// there were enough tokens remaining at all of them, and which level denied the draw
+// if not. If the draw is denied, no tokens are drawn from any bucket.
+func (h *Hierarchy) Draw(path []string, n int64) HierarchyResult {
+	return h.DrawAt(path, h.now(), n)
+}
+
+// DrawAt draws n tokens from the bucket at every level of the path, returning whether
+// there were enough tokens remaining at all of them, and which level denied the draw
+// if not. If the draw is denied, no tokens are drawn from any bucket.
+func (h *Hierarchy) DrawAt(path []string, t time.Time, n int64) HierarchyResult {
+	buckets := h.lock(path)
+	defer unlockAll(buckets)
+
+	res := h.check(buckets, t, n)
+	if !res.OK {
+		return res
+	}
+	for _, b := range buckets {
+		b.tokens -= n
+	}
+	res.Remaining -= n
+	return res
+}
+
+// Allow draws 1 token from the bucket at every level of the path, returning whether there was one available.
+func (h *Hierarchy) Allow(path []string) bool {
+	return h.Draw(path, 1).OK
+}
+
+// AllowN draws n tokens from the bucket at every level of the path, returning whether
+// there were enough tokens remaining at all of them.
+func (h *Hierarchy) AllowN(path []string, n int64) bool {
+	return h.Draw(path, n).OK
+}
+
+// Remaining returns the number of tokens which can be drawn at every level of the path.
+func (h *Hierarchy) Remaining(path []string) int64 {
+	return h.RemainingAt(path, h.now())
+}
+
+// RemainingAt returns the number of tokens which can be drawn at every level of the
+// path at the specified time, which is the number remaining in the tightest bucket.
+func (h *Hierarchy) RemainingAt(path []string, t time.Time) int64 {
+	return h.CanDrawAt(path, t, 0).Remaining
+}
+
+// RetryAfter returns how long until n tokens can be drawn at every level of the path.
+func (h *Hierarchy) RetryAfter(path []string, n int64) time.Duration {
+	return h.RetryAfterAt(path, h.now(), n)
+}
+
+// RetryAfterAt returns how long after the specified time until n tokens can be drawn
+// at every level of the path, which is the longest wait of any level. This is zero if
+// they can be drawn now, or InfDuration if n exceeds the burst quantity of any level.
+func (h *Hierarchy) RetryAfterAt(path []string, t time.Time, n int64) time.Duration {
+	return h.CanDrawAt(path, t, n).RetryAfter
+}
+
+// Bucket returns the bucket at the deepest level of the path, creating it if necessary.
+func (h *Hierarchy) Bucket(path []string) *Bucket {
+	buckets := h.getOrCreate(path)
+	if len(buckets) == 0 {
+		return nil
+	}
+	return buckets[len(buckets)-1]
+}
+
+// Len returns the number of buckets held at each level.
+func (h *Hierarchy) Len() []int {
+	h.bucketsMux.RLock()
+	defer h.bucketsMux.RUnlock()
+
+	lens := make([]int, len(h.Levels))
+	for i := range h.buckets {
+		if i < len(lens) {
+			lens[i] = len(h.buckets[i])
+		}
+	}
+	return lens
+}
+
+// Purge removes buckets which return true for IsReset, returning
+// the number of buckets which were removed during the iteration.
+func (h *Hierarchy) Purge() int {
+	now := h.now()
+	removed := 0
+
+	h.bucketsMux.Lock()
+	defer h.bucketsMux.Unlock()
+	for _, level := range h.buckets {
+		for key, b := range level {
+			b.mux.Lock()
+			full := b.fullAt(now)
+			b.mux.Unlock()
+			if full {
+				delete(level, key)
+				removed++
+			}
+		}
+	}
+	return removed
+}
+
+// now returns the current time from the hierarchy's clock.
+func (h *Hierarchy) now() time.Time {
+	return clockOrReal(h.Clock).Now()
+}
+
+// check refills the buckets at the specified time and reports whether n tokens can be
+// drawn from all of them, without drawing them. The highest level which denies n is
+// reported, since lower levels can not help until it has refilled.
+//
+// the buckets must be locked for the duration of the call.
+func (h *Hierarchy) check(buckets []*Bucket, t time.Time, n int64) HierarchyResult {
+	res := HierarchyResult{OK: true, Denied: -1, Remaining: math.MaxInt64}
+	for i, b := range buckets {
+		b.refill(t)
+		res.Remaining = min(res.Remaining, b.tokens)
+		if d := timeUntil(b.state(), t, n); d > res.RetryAfter {
+			res.RetryAfter = d
+		}
+		if b.tokens < n && res.OK {
+			res.OK = false
+			res.Denied = i
+			res.DeniedBy = h.Levels[i].Name
+		}
+	}
+	if res.Remaining < 0 {
+		res.Remaining = 0
+	}
+	return res
+}
+
+// lock returns the buckets at each level of the path, write-locked from the root
+// down. Parents are always locked before their children, and siblings are never
+// locked together, so concurrent draws which share parents can not deadlock.
+func (h *Hierarchy) lock(path []string) []*Bucket {
+	buckets := h.getOrCreate(path)
+	for _, b := range buckets {
+		b.mux.Lock()
+	}
+	return buckets
+}
+
+// unlockAll unlocks the buckets, in reverse order.
+func unlockAll(buckets []*Bucket) {
+	for i := len(buckets) - 1; i >= 0; i-- {
+		buckets[i].mux.Unlock()
+	}
+}
+
+// getOrCreate returns the bucket at each level of the path, creating them if necessary.
+// Elements of the path beyond the deepest level are ignored.
+func (h *Hierarchy) getOrCreate(path []string) []*Bucket {
+	depth := min(int64(len(path)), int64(len(h.Levels)))
+	buckets := make([]*Bucket, depth)
+	keys := make([]string, depth)
+	for i := range keys {
+		// the separator can not be confused with a path element,
+		// unless the elements themselves contain it.
+		keys[i] = strings.Join(path[:i+1], "\x00")
+	}
+
+	h.bucketsMux.RLock()
+	missing := len(h.buckets) < len(keys)
+	for i := 0; i < len(keys) && !missing; i++ {
+		buckets[i] = h.buckets[i][keys[i]]
+		missing = buckets[i] == nil
+	}
+	h.bucketsMux.RUnlock()
+	if !missing {
+		return buckets
+	}
+
+	h.bucketsMux.Lock()
+	defer h.bucketsMux.Unlock()
+	for len(h.buckets) < len(keys) {
+		h.buckets = append(h.buckets, make(map[string]*Bucket))
+	}
+	for i, key := range keys {
+		b, ok := h.buckets[i][key]
+		if !ok {
+			level := h.Levels[i]
+			b = NewBucket(level.Limit, level.Burst, level.Refill)
+			b.Clock = h.Clock
+			h.buckets[i][key] = b
+		}
+		buckets[i] = b
+	}
+	return buckets
+}
@@ -0,0 +1,98 @@
+package gorl
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestHierarchy() *Hierarchy {
+	return NewHierarchy(
+		Level{Name: "global", Limit: 10, Burst: 10, Refill: time.Minute},
+		Level{Name: "org", Limit: 6, Burst: 6, Refill: time.Minute},
+		Level{Name: "user", Limit: 4, Burst: 4, Refill: time.Minute},
+	)
+}
+
+func TestHierarchy_Draw(t *testing.T) {
+	now := time.Now()
+	h := newTestHierarchy()
+	alice := []string{"api", "acme", "alice"}
+	bob := []string{"api", "acme", "bob"}
+
+	if res := h.DrawAt(alice, now, 4); !res.OK || res.Remaining != 0 {
+		t.Error("expected to draw 4 tokens leaving 0, got", res)
+	}
+	res := h.DrawAt(alice, now, 1)
+	if res.OK || res.DeniedBy != "user" || res.Denied != 2 {
+		t.Error("expected user level to deny the draw, got", res)
+	}
+	if res.RetryAfter != time.Minute {
+		t.Error("expected retry after 1m, got", res.RetryAfter)
+	}
+
+	// bob shares the org bucket, which has 2 tokens left
+	res = h.DrawAt(bob, now, 3)
+	if res.OK || res.DeniedBy != "org" {
+		t.Error("expected org level to deny the draw, got", res)
+	}
+	if tokens := h.Bucket(bob).TokensAt(now); tokens != 4 {
+		t.Error("expected denied draw to leave bob's bucket untouched, got", tokens)
+	}
+	if tokens := h.Bucket([]string{"api"}).TokensAt(now); tokens != 6 {
+		t.Error("expected global bucket to have 6 tokens, got", tokens)
+	}
+
+	// another org is only limited by the global bucket
+	res = h.DrawAt([]string{"api", "initech", "alice"}, now, 4)
+	if !res.OK {
+		t.Error("expected to draw 4 tokens, got", res)
+	}
+	res = h.DrawAt([]string{"api", "umbrella"}, now, 3)
+	if res.OK || res.DeniedBy != "global" {
+		t.Error("expected global level to deny the draw, got", res)
+	}
+}
+
+func TestHierarchy_Concurrent(t *testing.T) {
+	now := time.Now()
+	h := NewHierarchy(
+		Level{Name: "global", Limit: 100, Burst: 100, Refill: time.Hour},
+		Level{Name: "user", Limit: 30, Burst: 30, Refill: time.Hour},
+	)
+
+	var wg sync.WaitGroup
+	var mux sync.Mutex
+	allowed := 0
+	for _, user := range []string{"a", "b", "c", "d", "e"} {
+		for i := 0; i < 40; i++ {
+			wg.Add(1)
+			go func(user string) {
+				defer wg.Done()
+				if h.DrawAt([]string{"api", user}, now, 1).OK {
+					mux.Lock()
+					allowed++
+					mux.Unlock()
+				}
+			}(user)
+		}
+	}
+	wg.Wait()
+
+	if allowed != 100 {
+		t.Error("expected exactly 100 draws to be allowed, got", allowed)
+	}
+}
+
+func TestHierarchy_Purge(t *testing.T) {
+	h := newTestHierarchy()
+	h.Allow([]string{"api", "acme", "alice"})
+	h.Bucket([]string{"api", "acme", "bob"})
+
+	if removed := h.Purge(); removed != 1 {
+		t.Error("expected to purge 1 bucket, got", removed)
+	}
+	if lens := h.Len(); lens[0] != 1 || lens[1] != 1 || lens[2] != 1 {
+		t.Error("expected 1 bucket at each level, got", lens)
+	}
+}
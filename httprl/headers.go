@@ -0,0 +1,214 @@
+package httprl
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zytekaron/gorl"
+)
+
+// ErrNoHeaders is returned by ParseHeaders when the response has no rate limit headers.
+var ErrNoHeaders = errors.New("httprl: no rate limit headers")
+
+// Headers describes the state of a bucket using the RateLimit header fields
+// from draft-ietf-httpapi-ratelimit-headers, and the legacy X-RateLimit-*
+// header fields which many clients still expect.
+type Headers struct {
+	// Limit is the number of tokens available when the bucket is full.
+	Limit int64
+	// Remaining is the number of tokens which can currently be drawn.
+	Remaining int64
+	// Reset is the time until the bucket next refills.
+	Reset time.Duration
+	// Policy describes how the bucket refills. It is zero if not known.
+	Policy Policy
+}
+
+// Policy is the quota policy of a bucket, as sent in RateLimit-Policy.
+// It is formatted as "{Quota};w={Window};burst={Burst}", with the window
+// in seconds, where Quota tokens are refilled every Window up to Burst.
+type Policy struct {
+	Quota  int64
+	Window time.Duration
+	Burst  int64
+}
+
+// BucketHeaders returns the headers describing the bucket.
+func BucketHeaders(b *gorl.Bucket) Headers {
+	c := b.Clock
+	if c == nil {
+		c = gorl.RealClock
+	}
+	return BucketHeadersAt(b, c.Now())
+}
+
+// BucketHeadersAt returns the headers describing the bucket at the specified time.
+func BucketHeadersAt(b *gorl.Bucket, t time.Time) Headers {
+	cfg := b.Config()
+	return Headers{
+		Limit:     cfg.Burst,
+		Remaining: b.RemainingAt(t),
+		Reset:     b.NextRefillAt(t).Sub(t),
+		Policy: Policy{
+			Quota:  cfg.Limit,
+			Window: cfg.Refill,
+			Burst:  cfg.Burst,
+		},
+	}
+}
+
+// ManagerHeaders returns the headers describing the bucket with the given id.
+func ManagerHeaders(bm *gorl.BucketManager, id string) Headers {
+	return ManagerHeadersAt(bm, id, clock(bm).Now())
+}
+
+// ManagerHeadersAt returns the headers describing the bucket with the given id at the specified time.
+//
+// The policy is taken from the manager's parameters for the id, which
+// are the parameters of the bucket unless it was added using Set.
+func ManagerHeadersAt(bm *gorl.BucketManager, id string, t time.Time) Headers {
+	cfg := bm.Config(id)
+	return Headers{
+		Limit:     cfg.Burst,
+		Remaining: bm.RemainingAt(id, t),
+		Reset:     bm.NextRefillAt(id, t).Sub(t),
+		Policy: Policy{
+			Quota:  cfg.Limit,
+			Window: cfg.Refill,
+			Burst:  cfg.Burst,
+		},
+	}
+}
+
+// Write sets the rate limit header fields on the header.
+func (h Headers) Write(header http.Header) {
+	h.WriteAt(header, time.Now())
+}
+
+// WriteAt sets the rate limit header fields on the header, using the specified
+// time as the current time for the legacy X-RateLimit-Reset timestamp.
+func (h Headers) WriteAt(header http.Header, t time.Time) {
+	limit := strconv.FormatInt(h.Limit, 10)
+	remaining := strconv.FormatInt(h.Remaining, 10)
+
+	header.Set("RateLimit-Limit", limit)
+	header.Set("RateLimit-Remaining", remaining)
+	header.Set("RateLimit-Reset", strconv.FormatInt(seconds(h.Reset), 10))
+	if h.Policy != (Policy{}) {
+		header.Set("RateLimit-Policy", h.Policy.String())
+	}
+
+	header.Set("X-RateLimit-Limit", limit)
+	header.Set("X-RateLimit-Remaining", remaining)
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(t.Add(h.Reset).Unix(), 10))
+}
+
+// String formats the policy as the value of a RateLimit-Policy header field.
+func (p Policy) String() string {
+	s := fmt.Sprintf("%d;w=%d", p.Quota, seconds(p.Window))
+	if p.Burst != 0 {
+		s += fmt.Sprintf(";burst=%d", p.Burst)
+	}
+	return s
+}
+
+// ParseHeaders parses the rate limit header fields from a response header.
+func ParseHeaders(header http.Header) (Headers, error) {
+	return ParseHeadersAt(header, time.Now())
+}
+
+// ParseHeadersAt parses the rate limit header fields from a response header, using
+// the specified time as the current time for the legacy X-RateLimit-Reset timestamp.
+//
+// The RateLimit fields are used if present, otherwise the X-RateLimit fields are used.
+func ParseHeadersAt(header http.Header, t time.Time) (Headers, error) {
+	prefix := "RateLimit-"
+	if header.Get("RateLimit-Limit") == "" && header.Get("RateLimit-Remaining") == "" {
+		prefix = "X-RateLimit-"
+		if header.Get("X-RateLimit-Limit") == "" && header.Get("X-RateLimit-Remaining") == "" {
+			return Headers{}, ErrNoHeaders
+		}
+	}
+
+	var h Headers
+	var err error
+	if h.Limit, err = parseField(header, prefix+"Limit"); err != nil {
+		return Headers{}, err
+	}
+	if h.Remaining, err = parseField(header, prefix+"Remaining"); err != nil {
+		return Headers{}, err
+	}
+	reset, err := parseField(header, prefix+"Reset")
+	if err != nil {
+		return Headers{}, err
+	}
+	h.Reset = time.Duration(reset) * time.Second
+
+	// the legacy reset field is usually a unix timestamp,
+	// but some servers send the number of seconds instead.
+	if prefix == "X-RateLimit-" && reset > 1e9 {
+		h.Reset = time.Unix(reset, 0).Sub(t)
+		if h.Reset < 0 {
+			h.Reset = 0
+		}
+	}
+
+	if value := header.Get("RateLimit-Policy"); value != "" {
+		if h.Policy, err = ParsePolicy(value); err != nil {
+			return Headers{}, err
+		}
+	}
+	return h, nil
+}
+
+// ParsePolicy parses the value of a RateLimit-Policy header field. If several
+// policies are listed, the first one is returned. Unknown parameters are ignored.
+func ParsePolicy(value string) (Policy, error) {
+	first := strings.TrimSpace(strings.SplitN(value, ",", 2)[0])
+	params := strings.Split(first, ";")
+
+	var p Policy
+	var err error
+	if p.Quota, err = strconv.ParseInt(strings.TrimSpace(params[0]), 10, 64); err != nil {
+		return Policy{}, fmt.Errorf("httprl: invalid policy quota %q", params[0])
+	}
+	for _, param := range params[1:] {
+		key, val, _ := strings.Cut(strings.TrimSpace(param), "=")
+		n, err := strconv.ParseInt(val, 10, 64)
+		switch key {
+		case "w":
+			if err != nil {
+				return Policy{}, fmt.Errorf("httprl: invalid policy window %q", val)
+			}
+			p.Window = time.Duration(n) * time.Second
+		case "burst":
+			if err != nil {
+				return Policy{}, fmt.Errorf("httprl: invalid policy burst %q", val)
+			}
+			p.Burst = n
+		}
+	}
+	return p, nil
+}
+
+func parseField(header http.Header, name string) (int64, error) {
+	value := header.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("httprl: invalid %s header %q", name, value)
+	}
+	return n, nil
+}
+
+// seconds rounds a duration up to a whole number of seconds.
+func seconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,111 @@
+package httprl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zytekaron/gorl"
+)
+
+func TestBucketHeaders(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := gorl.NewBucket(5, 20, time.Minute)
+	b.DrawAt(now, 8)
+
+	h := BucketHeadersAt(b, now.Add(15*time.Second))
+	want := Headers{
+		Limit:     20,
+		Remaining: 12,
+		Reset:     45 * time.Second,
+		Policy:    Policy{Quota: 5, Window: time.Minute, Burst: 20},
+	}
+	if h != want {
+		t.Errorf("expected headers %+v, got %+v", want, h)
+	}
+}
+
+func TestHeaders_Write(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	h := Headers{
+		Limit:     20,
+		Remaining: 12,
+		Reset:     1500 * time.Millisecond,
+		Policy:    Policy{Quota: 5, Window: time.Minute, Burst: 20},
+	}
+
+	header := http.Header{}
+	h.WriteAt(header, now)
+
+	want := map[string]string{
+		"RateLimit-Limit":       "20",
+		"RateLimit-Remaining":   "12",
+		"RateLimit-Reset":       "2",
+		"RateLimit-Policy":      "5;w=60;burst=20",
+		"X-RateLimit-Limit":     "20",
+		"X-RateLimit-Remaining": "12",
+		"X-RateLimit-Reset":     "1700000001",
+	}
+	for name, value := range want {
+		if got := header.Get(name); got != value {
+			t.Errorf("expected %s to be %q, got %q", name, value, got)
+		}
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	want := Headers{
+		Limit:     20,
+		Remaining: 12,
+		Reset:     2 * time.Second,
+		Policy:    Policy{Quota: 5, Window: time.Minute, Burst: 20},
+	}
+
+	header := http.Header{}
+	want.WriteAt(header, now)
+	got, err := ParseHeadersAt(header, now)
+	if err != nil {
+		t.Fatal("unexpected error parsing headers:", err)
+	}
+	if got != want {
+		t.Errorf("expected headers %+v, got %+v", want, got)
+	}
+
+	// legacy headers only, with a unix timestamp reset
+	legacy := http.Header{}
+	legacy.Set("X-RateLimit-Limit", "60")
+	legacy.Set("X-RateLimit-Remaining", "0")
+	legacy.Set("X-RateLimit-Reset", "1700000030")
+	got, err = ParseHeadersAt(legacy, now)
+	if err != nil {
+		t.Fatal("unexpected error parsing legacy headers:", err)
+	}
+	if got.Limit != 60 || got.Remaining != 0 || got.Reset != 30*time.Second {
+		t.Errorf("unexpected legacy headers %+v", got)
+	}
+
+	if _, err := ParseHeaders(http.Header{}); err != ErrNoHeaders {
+		t.Error("expected ErrNoHeaders, got", err)
+	}
+
+	bad := http.Header{}
+	bad.Set("RateLimit-Limit", "lots")
+	if _, err := ParseHeaders(bad); err == nil {
+		t.Error("expected an error for an invalid limit")
+	}
+}
+
+func TestMiddlewareHeaders(t *testing.T) {
+	bm := gorl.New(1, 2, time.Minute)
+	h := Middleware(bm, WithHeaders())(ok)
+
+	rec := serve(h, httptest.NewRequest("GET", "/", nil))
+	if got := rec.Header().Get("RateLimit-Remaining"); got != "1" {
+		t.Error("expected RateLimit-Remaining to be 1, got", got)
+	}
+	if got := rec.Header().Get("RateLimit-Policy"); got != "1;w=60;burst=2" {
+		t.Error("expected RateLimit-Policy to be 1;w=60;burst=2, got", got)
+	}
+}
@@ -0,0 +1,252 @@
+// Package httprl provides net/http middleware which rate limits requests
+// using a gorl.BucketManager, or any other gorl.KeyedLimiter.
+package httprl
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zytekaron/gorl"
+)
+
+// KeyFunc returns the id of the bucket that a request draws from, and
+// whether one could be determined. Requests without a key are not limited.
+type KeyFunc func(r *http.Request) (string, bool)
+
+// CostFunc returns the number of tokens that a request draws.
+type CostFunc func(r *http.Request) int64
+
+// Option configures the middleware.
+type Option func(*config)
+
+type config struct {
+	key     KeyFunc
+	cost    CostFunc
+	deny    http.Handler
+	headers bool
+	cm      *gorl.ConcurrencyManager
+}
+
+// WithKeyFunc sets the function used to find the bucket for each request.
+// The default is RemoteIP.
+func WithKeyFunc(fn KeyFunc) Option {
+	return func(c *config) {
+		c.key = fn
+	}
+}
+
+// WithCostFunc sets the function used to find the number of tokens drawn
+// by each request. The default draws 1 token for every request.
+func WithCostFunc(fn CostFunc) Option {
+	return func(c *config) {
+		c.cost = fn
+	}
+}
+
+// WithDenyHandler sets the handler called when a request is rate limited.
+// The Retry-After header is set before the handler is called, unless the
+// request costs more than the burst quantity and can never be allowed, or
+// was denied by WithConcurrency.
+// The default responds with 429 Too Many Requests.
+func WithDenyHandler(h http.Handler) Option {
+	return func(c *config) {
+		c.deny = h
+	}
+}
+
+// WithHeaders enables the RateLimit and X-RateLimit-* response header
+// fields, which are set on every limited response using ManagerHeaders.
+// They are only set if the middleware limits using a *gorl.BucketManager.
+func WithHeaders() Option {
+	return func(c *config) {
+		c.headers = true
+	}
+}
+
+// WithConcurrency also limits the number of requests in flight for each
+// key using the ConcurrencyManager. Each request holds one slot until the
+// next handler returns. Requests which are denied a slot are passed to the
+// deny handler without drawing tokens. No Retry-After header is set for them,
+// since there is no way to know when a slot will be released.
+func WithConcurrency(cm *gorl.ConcurrencyManager) Option {
+	return func(c *config) {
+		c.cm = cm
+	}
+}
+
+// Middleware returns middleware which draws tokens from the limiter for each
+// request, and calls the deny handler instead of the next handler if there
+// were not enough tokens remaining.
+//
+// Any gorl.KeyedLimiter can be used, such as a gorl.Manager of another
+// algorithm or a rules.Limiter. If it is a *gorl.BucketManager, its clock
+// is used as the current time, and WithHeaders can describe its buckets.
+func Middleware(l gorl.KeyedLimiter, opts ...Option) func(http.Handler) http.Handler {
+	c := &config{
+		key:  RemoteIP,
+		cost: func(*http.Request) int64 { return 1 },
+		deny: http.HandlerFunc(tooManyRequests),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	bm, _ := l.(*gorl.BucketManager)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, ok := c.key(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if c.cm != nil {
+				release, ok := c.cm.TryAcquire(id, 1)
+				if !ok {
+					c.deny.ServeHTTP(w, r)
+					return
+				}
+				defer release()
+			}
+
+			cost := c.cost(r)
+			var allowed bool
+			var delay time.Duration
+			if bm != nil {
+				now := clock(bm).Now()
+				allowed = bm.DrawAt(id, now, cost)
+				if c.headers {
+					ManagerHeadersAt(bm, id, now).WriteAt(w.Header(), now)
+				}
+				if !allowed {
+					// the delay includes the manager's quotas, if it has any.
+					delay = bm.RetryAfterAt(id, now, cost)
+				}
+			} else if allowed = l.AllowN(id, cost); !allowed {
+				delay = l.RetryAfter(id, cost)
+			}
+			if !allowed {
+				if delay < gorl.InfDuration {
+					w.Header().Set("Retry-After", retryAfter(delay))
+				}
+				c.deny.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clock returns the manager's clock, or gorl.RealClock if it has none.
+func clock(bm *gorl.BucketManager) gorl.Clock {
+	if bm.Clock == nil {
+		return gorl.RealClock
+	}
+	return bm.Clock
+}
+
+func tooManyRequests(w http.ResponseWriter, _ *http.Request) {
+	http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+}
+
+// retryAfter formats a delay as a whole number of seconds, rounded up.
+func retryAfter(d time.Duration) string {
+	secs := seconds(d)
+	if secs < 1 {
+		secs = 1
+	}
+	return strconv.FormatInt(secs, 10)
+}
+
+// RemoteIP uses the IP address of the client connection as the key.
+func RemoteIP(r *http.Request) (string, bool) {
+	addr, ok := remoteAddr(r)
+	if !ok {
+		return "", false
+	}
+	return addr.String(), true
+}
+
+// ForwardedFor returns a KeyFunc which uses the client IP address from the
+// X-Forwarded-For header as the key, if the request was made by one of the
+// trusted proxies. The header is read from right to left, skipping trusted
+// proxies, so that clients cannot choose their own key by forging it.
+//
+// The IP address of the connection is used if it is not a trusted proxy.
+func ForwardedFor(trusted ...netip.Prefix) KeyFunc {
+	isTrusted := func(addr netip.Addr) bool {
+		for _, prefix := range trusted {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(r *http.Request) (string, bool) {
+		client, ok := remoteAddr(r)
+		if !ok {
+			return "", false
+		}
+
+		var hops []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(header, ",")...)
+		}
+		for i := len(hops) - 1; i >= 0 && isTrusted(client); i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			client = addr.Unmap()
+		}
+		return client.String(), true
+	}
+}
+
+// Header returns a KeyFunc which uses the value of a request header as the key.
+func Header(name string) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		value := r.Header.Get(name)
+		return value, value != ""
+	}
+}
+
+// Cookie returns a KeyFunc which uses the value of a cookie as the key.
+func Cookie(name string) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		cookie, err := r.Cookie(name)
+		if err != nil || cookie.Value == "" {
+			return "", false
+		}
+		return cookie.Value, true
+	}
+}
+
+// FirstOf returns a KeyFunc which uses the first key found by the provided functions.
+func FirstOf(fns ...KeyFunc) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		for _, fn := range fns {
+			if id, ok := fn(r); ok {
+				return id, true
+			}
+		}
+		return "", false
+	}
+}
+
+func remoteAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
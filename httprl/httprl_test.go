@@ -0,0 +1,201 @@
+package httprl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/zytekaron/gorl"
+	"github.com/zytekaron/gorl/gorltest"
+)
+
+var ok = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestMiddleware(t *testing.T) {
+	bm := gorl.New(1, 2, time.Minute)
+	h := Middleware(bm)(ok)
+
+	for i := 0; i < 2; i++ {
+		rec := serve(h, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected request %d to succeed, got status %d", i, rec.Code)
+		}
+	}
+
+	rec := serve(h, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Error("expected request to be limited, got status", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "60" {
+		t.Error("expected Retry-After to be 60, got", got)
+	}
+
+	// a different client has its own bucket
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.0.2.2:1234"
+	if rec := serve(h, r); rec.Code != http.StatusOK {
+		t.Error("expected request from another client to succeed, got status", rec.Code)
+	}
+}
+
+func TestMiddlewareQuotaRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := gorltest.NewFakeClock(now)
+	bm := gorl.New(5, 5, time.Second)
+	bm.Clock = clock
+	bm.Quotas = gorl.NewManager(func(string) *gorl.Quota {
+		q := gorl.NewQuota(1, gorl.Daily, time.UTC)
+		q.Clock = clock
+		return q
+	})
+	h := Middleware(bm)(ok)
+
+	serve(h, httptest.NewRequest("GET", "/", nil))
+	rec := serve(h, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Error("expected request to be limited by the quota, got status", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "43200" {
+		t.Error("expected Retry-After to be the end of the quota window, got", got)
+	}
+}
+
+func TestMiddlewareKeyedLimiter(t *testing.T) {
+	clock := gorltest.NewFakeClock(time.Now())
+	m := gorl.NewManager(func(string) *gorl.SlidingLog {
+		l := gorl.NewSlidingLog(2, time.Minute)
+		l.Clock = clock
+		return l
+	})
+	h := Middleware(m, WithHeaders())(ok)
+
+	serve(h, httptest.NewRequest("GET", "/", nil))
+	clock.Advance(30 * time.Second)
+	if rec := serve(h, httptest.NewRequest("GET", "/", nil)); rec.Code != http.StatusOK {
+		t.Error("expected second request to succeed, got status", rec.Code)
+	}
+
+	rec := serve(h, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Error("expected request to be limited, got status", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "30" {
+		t.Error("expected Retry-After to be when the first request leaves the window, got", got)
+	}
+	if got := rec.Header().Get("RateLimit-Limit"); got != "" {
+		t.Error("expected no rate limit headers for a Manager, got", got)
+	}
+}
+
+func TestMiddlewareOptions(t *testing.T) {
+	bm := gorl.New(1, 10, time.Minute)
+	denied := false
+	h := Middleware(bm,
+		WithKeyFunc(Header("X-API-Key")),
+		WithCostFunc(func(r *http.Request) int64 { return 6 }),
+		WithDenyHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			denied = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})),
+	)(ok)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-API-Key", "key")
+	if rec := serve(h, r); rec.Code != http.StatusOK {
+		t.Error("expected first request to succeed, got status", rec.Code)
+	}
+	if rec := serve(h, r); rec.Code != http.StatusServiceUnavailable || !denied {
+		t.Error("expected second request to be denied by the custom handler, got status", rec.Code)
+	}
+
+	// requests without a key are not limited
+	for i := 0; i < 3; i++ {
+		if rec := serve(h, httptest.NewRequest("GET", "/", nil)); rec.Code != http.StatusOK {
+			t.Error("expected request without a key to succeed, got status", rec.Code)
+		}
+	}
+}
+
+func TestMiddlewareConcurrency(t *testing.T) {
+	bm := gorl.New(1, 10, time.Minute)
+	cm := gorl.NewConcurrencyManager(1)
+
+	var inner *httptest.ResponseRecorder
+	var h http.Handler
+	h = Middleware(bm, WithConcurrency(cm))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a second request from the same client while this one is in flight
+		if inner == nil {
+			inner = serve(h, httptest.NewRequest("GET", "/", nil))
+		}
+	}))
+
+	serve(h, httptest.NewRequest("GET", "/", nil))
+	if inner.Code != http.StatusTooManyRequests {
+		t.Error("expected concurrent request to be limited, got status", inner.Code)
+	}
+	if got := inner.Header().Get("Retry-After"); got != "" {
+		t.Error("expected no Retry-After for a concurrency denial, got", got)
+	}
+	if tokens := bm.Tokens("192.0.2.1"); tokens != 9 {
+		t.Error("expected denied request to draw no tokens, got", tokens)
+	}
+	if n := cm.InFlight("192.0.2.1"); n != 0 {
+		t.Error("expected slot to be released, got", n)
+	}
+}
+
+func TestForwardedFor(t *testing.T) {
+	fn := ForwardedFor(netip.MustParsePrefix("10.0.0.0/8"))
+
+	tests := []struct {
+		remote string
+		header string
+		want   string
+	}{
+		{"10.0.0.1:80", "203.0.113.7", "203.0.113.7"},
+		{"10.0.0.1:80", "198.51.100.1, 203.0.113.7, 10.0.0.2", "203.0.113.7"},
+		{"10.0.0.1:80", "10.0.0.3, 10.0.0.2", "10.0.0.3"},
+		{"10.0.0.1:80", "", "10.0.0.1"},
+		{"203.0.113.9:80", "198.51.100.1", "203.0.113.9"},
+		{"10.0.0.1:80", "garbage", "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.header != "" {
+			r.Header.Set("X-Forwarded-For", tt.header)
+		}
+		if got, _ := fn(r); got != tt.want {
+			t.Errorf("remote %s with header %q: expected key %s, got %s", tt.remote, tt.header, tt.want, got)
+		}
+	}
+}
+
+func TestKeyFuncs(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "[::ffff:192.0.2.1]:443"
+	r.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+
+	if got, _ := RemoteIP(r); got != "192.0.2.1" {
+		t.Error("expected remote ip to be 192.0.2.1, got", got)
+	}
+	if got, _ := Cookie("session")(r); got != "abc" {
+		t.Error("expected cookie key to be abc, got", got)
+	}
+	if _, ok := Header("X-API-Key")(r); ok {
+		t.Error("expected missing header to not produce a key")
+	}
+	if got, _ := FirstOf(Header("X-API-Key"), Cookie("session"))(r); got != "abc" {
+		t.Error("expected first available key to be abc, got", got)
+	}
+}
@@ -0,0 +1,100 @@
+package gorl
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Janitor periodically removes buckets from a BucketManager
+// which no longer hold useful information. See StartJanitor.
+type Janitor struct {
+	cancel  context.CancelFunc
+	done    chan struct{}
+	evicted int64
+}
+
+// StartJanitor starts a goroutine which calls Sweep at each interval, until
+// ctx is done or the returned Janitor is stopped. This bounds the memory used
+// by managers with a large number of short-lived ids, such as IP addresses.
+//
+// The interval must be positive, or StartJanitor panics.
+func (m *BucketManager) StartJanitor(ctx context.Context, interval time.Duration) *Janitor {
+	if interval <= 0 {
+		panic("gorl: non-positive interval for StartJanitor")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	j := &Janitor{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	clock := clockOrReal(m.Clock)
+	go func() {
+		defer close(j.done)
+
+		for {
+			timer := clock.NewTimer(interval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C():
+				atomic.AddInt64(&j.evicted, int64(m.Sweep()))
+			}
+		}
+	}()
+	return j
+}
+
+// Stop stops the janitor and waits for its goroutine to exit.
+func (j *Janitor) Stop() {
+	j.cancel()
+	<-j.done
+}
+
+// Done returns a channel which is closed once the janitor has stopped.
+func (j *Janitor) Done() <-chan struct{} {
+	return j.done
+}
+
+// Evicted returns the total number of buckets removed by the janitor.
+func (j *Janitor) Evicted() int64 {
+	return atomic.LoadInt64(&j.evicted)
+}
+
+// Sweep removes buckets which are fully refilled, as well as buckets which
+// have not been used for longer than IdleTTL, returning the number removed.
+func (m *BucketManager) Sweep() int {
+	return m.SweepAt(m.now())
+}
+
+// SweepAt removes buckets which are fully refilled at the specified time, as well as buckets
+// which have not been used for longer than IdleTTL, returning the number removed.
+//
+// If the store does not implement Sweeper, IdleTTL is ignored and only
+// buckets which are fully refilled are removed.
+//
+// If Quotas is set, quotas which have nothing drawn in their current window,
+// such as those whose window has ended, are also removed, but are not counted.
+func (m *BucketManager) SweepAt(t time.Time) int {
+	var removed int
+	var err error
+	if s, ok := m.store.(Sweeper); ok {
+		removed, err = s.Sweep(t, m.IdleTTL)
+	} else {
+		removed, err = m.store.Purge(t)
+	}
+	if m.Quotas != nil {
+		m.Quotas.purge(func(q *Quota) bool {
+			return q.IsResetAt(t)
+		})
+	}
+
+	m.handleError("", err)
+	if m.OnSweep != nil {
+		m.OnSweep(removed)
+	}
+	return removed
+}
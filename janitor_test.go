@@ -0,0 +1,101 @@
+package gorl
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBucketManager_Sweep(t *testing.T) {
+	now := time.Now()
+	bm := New(5, 20, time.Second)
+	bm.IdleTTL = time.Minute
+
+	bm.DrawAt("full", now, 0)
+	bm.DrawAt("refilled", now, 5)
+	bm.ForceDrawAt("drained", now, 1000)
+	bm.ForceDrawAt("active", now, 1000)
+
+	later := now.Add(30 * time.Second)
+	bm.DrawAt("active", later, 0)
+
+	var reported int
+	bm.OnSweep = func(evicted int) {
+		reported = evicted
+	}
+
+	// drained is neither full nor idle yet
+	if removed := bm.SweepAt(later); removed != 2 {
+		t.Error("expected 2 buckets to be swept, got", removed)
+	}
+	if reported != 2 {
+		t.Error("expected OnSweep to report 2 evictions, got", reported)
+	}
+
+	// drained has now been idle for longer than the ttl, but active has not
+	if removed := bm.SweepAt(now.Add(61 * time.Second)); removed != 1 {
+		t.Error("expected 1 idle bucket to be swept, got", removed)
+	}
+	if _, ok := bm.store.(*MemoryStore).get("active"); !ok {
+		t.Error("expected recently used bucket to not be swept")
+	}
+}
+
+func TestBucketManager_SweepBatches(t *testing.T) {
+	now := time.Now()
+	bm := New(5, 20, time.Second)
+
+	const buckets = 3*sweepBatch + 1
+	for i := 0; i < buckets; i++ {
+		bm.DrawAt(string(rune('a'+i)), now, 0)
+	}
+	if removed := bm.SweepAt(now); removed != buckets {
+		t.Errorf("expected %d buckets to be swept, got %d", buckets, removed)
+	}
+}
+
+func TestBucketManager_StartJanitor(t *testing.T) {
+	bm := New(5, 20, time.Second)
+	bm.Draw("a", 0)
+	bm.Draw("b", 0)
+
+	j := bm.StartJanitor(context.Background(), 5*time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for j.Evicted() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	j.Stop()
+
+	if evicted := j.Evicted(); evicted != 2 {
+		t.Error("expected the janitor to evict 2 buckets, got", evicted)
+	}
+	select {
+	case <-j.Done():
+	default:
+		t.Error("expected the janitor to be done after Stop")
+	}
+}
+
+func TestBucketManager_StartJanitorInterval(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected StartJanitor to panic with a non-positive interval")
+		}
+	}()
+
+	New(5, 20, time.Second).StartJanitor(context.Background(), 0)
+}
+
+func TestBucketManager_StartJanitorContext(t *testing.T) {
+	bm := New(5, 20, time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j := bm.StartJanitor(ctx, time.Hour)
+	cancel()
+
+	select {
+	case <-j.Done():
+	case <-time.After(time.Second):
+		t.Error("expected the janitor to stop when its context is cancelled")
+	}
+}
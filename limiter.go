@@ -0,0 +1,89 @@
+package gorl
+
+import (
+	"math"
+	"time"
+)
+
+// InfDuration is returned by RetryAfter when a request can never be allowed,
+// because it asks for more than the limiter can ever allow at once.
+const InfDuration = time.Duration(math.MaxInt64)
+
+// Limiter is a rate limiting algorithm for a single key, such as a Bucket.
+// Implementations must be safe for concurrent use.
+type Limiter interface {
+	// Allow reports whether one request may happen now, and records it if so.
+	Allow() bool
+	// AllowN reports whether n requests may happen now, and records them if so.
+	// If not, nothing is recorded.
+	AllowN(n int64) bool
+	// Remaining returns the number of requests which may happen now.
+	Remaining() int64
+	// RetryAfter returns how long until n requests may happen, assuming
+	// nothing else is recorded in the meantime. It returns zero if they
+	// may happen now, and InfDuration if they never may.
+	RetryAfter(n int64) time.Duration
+}
+
+// KeyedLimiter is a set of limiters identified by key, such as a BucketManager.
+// Implementations must be safe for concurrent use.
+type KeyedLimiter interface {
+	// Allow reports whether one request may happen now for the key, and records it if so.
+	Allow(id string) bool
+	// AllowN reports whether n requests may happen now for the key, and records them if so.
+	AllowN(id string, n int64) bool
+	// Remaining returns the number of requests which may happen now for the key.
+	Remaining(id string) int64
+	// RetryAfter returns how long until n requests may happen for the key.
+	RetryAfter(id string, n int64) time.Duration
+}
+
+var (
+	_ Limiter      = (*Bucket)(nil)
+	_ KeyedLimiter = (*BucketManager)(nil)
+	_ KeyedLimiter = (*Manager[*Bucket])(nil)
+)
+
+// Allow draws 1 token from the bucket, returning whether there was one available.
+func (b *Bucket) Allow() bool {
+	return b.Draw(1)
+}
+
+// AllowN draws n tokens from the bucket, returning whether there were enough
+// tokens remaining to draw without overdraft. It is the same as Draw.
+func (b *Bucket) AllowN(n int64) bool {
+	return b.Draw(n)
+}
+
+// RetryAfter returns how long until n tokens can be drawn from the bucket.
+func (b *Bucket) RetryAfter(n int64) time.Duration {
+	return b.RetryAfterAt(b.now(), n)
+}
+
+// RetryAfterAt returns how long after the specified time until n tokens can be drawn
+// from the bucket, which is zero if they can be drawn now, or InfDuration if n
+// exceeds the burst quantity.
+func (b *Bucket) RetryAfterAt(t time.Time, n int64) time.Duration {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.refill(t)
+
+	return timeUntil(b.state(), t, n)
+}
+
+// timeUntil returns how long after the specified time until a bucket in the
+// provided state (refilled at that time) will hold at least n tokens.
+func timeUntil(st State, t time.Time, n int64) time.Duration {
+	if st.Tokens >= n {
+		return 0
+	}
+	if n > st.Burst || st.Limit <= 0 || st.Refill <= 0 {
+		return InfDuration // the bucket never refills enough
+	}
+
+	// the next refill is counted from the anchored last update time,
+	// then one more interval is needed for each Limit tokens missing.
+	next := nextAfter(st.LastUpdate, t, st.Refill)
+	intervals := (n - st.Tokens + st.Limit - 1) / st.Limit
+	return next.Add(time.Duration(intervals-1) * st.Refill).Sub(t)
+}
@@ -0,0 +1,56 @@
+package gorl_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zytekaron/gorl"
+	"github.com/zytekaron/gorl/gorltest"
+)
+
+const id = "test_id"
+
+func TestKeyedLimiters(t *testing.T) {
+	clock := gorltest.NewFakeClock(time.Now())
+	bm := gorl.New(5, 20, time.Second)
+	bm.Clock = clock
+	m := gorl.NewManager(func(string) *gorl.Bucket {
+		b := gorl.NewBucket(5, 20, time.Second)
+		b.Clock = clock
+		return b
+	})
+
+	for name, kl := range map[string]gorl.KeyedLimiter{"BucketManager": bm, "Manager": m} {
+		if !kl.AllowN(id, 15) {
+			t.Errorf("%s: expected to be able to draw 15 tokens", name)
+		}
+		if kl.AllowN(id, 6) {
+			t.Errorf("%s: expected to NOT be able to draw 6 tokens (have 5)", name)
+		}
+		if remain := kl.Remaining(id); remain != 5 {
+			t.Errorf("%s: expected remaining tokens to be 5, got %d", name, remain)
+		}
+		if d := kl.RetryAfter(id, 10); d != time.Second {
+			t.Errorf("%s: expected to wait %s for 10 tokens, got %s", name, time.Second, d)
+		}
+		if remain := kl.Remaining("other"); remain != 20 {
+			t.Errorf("%s: expected a new key to have 20 tokens, got %d", name, remain)
+		}
+	}
+}
+
+func TestManager_Purge(t *testing.T) {
+	m := gorl.NewManager(gorl.NewBucketFactory(5, 20, time.Hour))
+	m.AllowN("full", 0)
+	m.AllowN("drawn", 1)
+
+	if removed := m.Purge(); removed != 1 {
+		t.Error("expected 1 limiter to be purged, got", removed)
+	}
+	if m.Len() != 1 {
+		t.Error("expected 1 limiter to remain, got", m.Len())
+	}
+	if m.Get("drawn").Remaining() != 19 {
+		t.Error("expected the drawn limiter to be kept")
+	}
+}
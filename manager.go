@@ -0,0 +1,166 @@
+package gorl
+
+import (
+	"sync"
+	"time"
+)
+
+// Manager is a thread-safe manager for Limiter instances of any algorithm.
+// Limiters are created by the factory when they are first queried, and can
+// be managed directly from this type.
+//
+// Unlike BucketManager, the limiters are always held in process memory.
+// BucketManager is not a Manager[*Bucket] because its buckets are held by
+// a Store, which may be shared between processes and only holds their
+// state, and because it applies resolvers, quotas and reconfiguration to
+// them by id. Use a Manager for other algorithms, and a BucketManager for
+// buckets; both implement KeyedLimiter.
+type Manager[L Limiter] struct {
+	factory     func(id string) L
+	limiters    map[string]L
+	limitersMux sync.RWMutex
+}
+
+// NewManager creates a new Manager which creates limiters using the factory.
+func NewManager[L Limiter](factory func(id string) L) *Manager[L] {
+	return &Manager[L]{
+		factory:  factory,
+		limiters: make(map[string]L),
+	}
+}
+
+// NewBucketFactory returns a factory for NewManager which creates
+// buckets with the provided parameters, like BucketManager does.
+func NewBucketFactory(limit, burst int64, refill time.Duration) func(id string) *Bucket {
+	return func(string) *Bucket {
+		return NewBucket(limit, burst, refill)
+	}
+}
+
+// Get gets a limiter from the Manager, creating it if necessary.
+func (m *Manager[L]) Get(id string) L {
+	return m.getOrCreate(id)
+}
+
+// Set adds a limiter to the Manager.
+func (m *Manager[L]) Set(id string, limiter L) {
+	m.limitersMux.Lock()
+	m.limiters[id] = limiter
+	m.limitersMux.Unlock()
+}
+
+// Delete removes a limiter from the Manager.
+func (m *Manager[L]) Delete(id string) {
+	m.limitersMux.Lock()
+	delete(m.limiters, id)
+	m.limitersMux.Unlock()
+}
+
+// Len returns the number of limiters held by the Manager.
+func (m *Manager[L]) Len() int {
+	m.limitersMux.RLock()
+	defer m.limitersMux.RUnlock()
+	return len(m.limiters)
+}
+
+// Range calls fn with each limiter until fn returns false.
+//
+// The manager is not locked while fn is called, so fn may use the manager.
+func (m *Manager[L]) Range(fn func(id string, limiter L) bool) {
+	m.limitersMux.RLock()
+	limiters := make(map[string]L, len(m.limiters))
+	for id, limiter := range m.limiters {
+		limiters[id] = limiter
+	}
+	m.limitersMux.RUnlock()
+
+	for id, limiter := range limiters {
+		if !fn(id, limiter) {
+			return
+		}
+	}
+}
+
+// Allow reports whether one request may happen now for the key, and records it if so.
+func (m *Manager[L]) Allow(id string) bool {
+	return m.getOrCreate(id).Allow()
+}
+
+// AllowN reports whether n requests may happen now for the key, and records them if so.
+func (m *Manager[L]) AllowN(id string, n int64) bool {
+	return m.getOrCreate(id).AllowN(n)
+}
+
+// Remaining returns the number of requests which may happen now for the key.
+func (m *Manager[L]) Remaining(id string) int64 {
+	return m.getOrCreate(id).Remaining()
+}
+
+// RetryAfter returns how long until n requests may happen for the key.
+func (m *Manager[L]) RetryAfter(id string, n int64) time.Duration {
+	return m.getOrCreate(id).RetryAfter(n)
+}
+
+// Purge removes limiters which hold no useful information, returning the number
+// removed. Only limiters which have an IsReset method are checked, and they
+// are removed when it returns true.
+func (m *Manager[L]) Purge() int {
+	return m.purge(func(limiter L) bool {
+		r, ok := any(limiter).(interface{ IsReset() bool })
+		return ok && r.IsReset()
+	})
+}
+
+// purge removes the limiters for which reset returns true, returning the number removed.
+//
+// The limiters are checked while holding only the read lock, and the write lock
+// is then taken to remove them. Each limiter is checked again before it is
+// removed, in case it was used in the meantime.
+func (m *Manager[L]) purge(reset func(limiter L) bool) int {
+	var candidates []string
+	m.limitersMux.RLock()
+	for id, limiter := range m.limiters {
+		if reset(limiter) {
+			candidates = append(candidates, id)
+		}
+	}
+	m.limitersMux.RUnlock()
+
+	if len(candidates) == 0 {
+		return 0
+	}
+
+	removed := 0
+	m.limitersMux.Lock()
+	for _, id := range candidates {
+		if limiter, ok := m.limiters[id]; ok && reset(limiter) {
+			delete(m.limiters, id)
+			removed++
+		}
+	}
+	m.limitersMux.Unlock()
+
+	return removed
+}
+
+// getOrCreate returns the limiter, creating it if necessary. The limiter is
+// checked for again once the write lock is held, so that concurrent calls
+// for a new id all return the same limiter rather than overwriting it.
+func (m *Manager[L]) getOrCreate(id string) L {
+	m.limitersMux.RLock()
+	limiter, ok := m.limiters[id]
+	m.limitersMux.RUnlock()
+	if ok {
+		return limiter
+	}
+
+	m.limitersMux.Lock()
+	defer m.limitersMux.Unlock()
+	if limiter, ok := m.limiters[id]; ok {
+		return limiter
+	}
+
+	limiter = m.factory(id)
+	m.limiters[id] = limiter
+	return limiter
+}
@@ -0,0 +1,331 @@
+package gorl
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Eviction selects which bucket a bounded MemoryStore removes when it is full.
+type Eviction int
+
+const (
+	// EvictLRU removes the least recently used bucket.
+	EvictLRU Eviction = iota
+	// EvictFullest removes the least recently used bucket which is fully refilled,
+	// since it holds no useful information, or the least recently used bucket if
+	// none are full. Finding a full bucket may require checking every bucket.
+	EvictFullest
+)
+
+// MemoryStore is a Store which holds Bucket instances in process memory.
+// It is the default store used by New.
+//
+// The number of buckets is unbounded unless MaxBuckets is set. The exported
+// fields must be set before the store is used, and must not be changed after.
+type MemoryStore struct {
+	// MaxBuckets is the maximum number of buckets held by the store. When a new
+	// bucket would exceed it, a bucket is removed according to Eviction. Zero
+	// means unbounded. Bounded stores must track the order in which buckets are
+	// used, so every operation takes the write lock. To spread this out, use a
+	// ShardedStore whose shards are each bounded; see NewShardedStoreFunc.
+	MaxBuckets int
+	// Eviction selects which bucket is removed when the store is full.
+	Eviction Eviction
+	// OnEvict is called with each bucket which is removed to make room for another.
+	OnEvict func(id string, bucket *Bucket)
+
+	buckets   map[string]*Bucket
+	recent    *list.List // ids, most recently used first, if bounded
+	elems     map[string]*list.Element
+	evictions int64
+	bucketMux sync.RWMutex
+}
+
+// NewMemoryStore creates a new, empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*Bucket),
+		recent:  list.New(),
+		elems:   make(map[string]*list.Element),
+	}
+}
+
+// NewBounded creates a new BucketManager whose buckets are held by a MemoryStore
+// which holds at most maxBuckets buckets, evicting the least recently used.
+func NewBounded(limit, burst int64, refill time.Duration, maxBuckets int) *BucketManager {
+	store := NewMemoryStore()
+	store.MaxBuckets = maxBuckets
+	return NewWithStore(limit, burst, refill, store)
+}
+
+// Apply refills the bucket at the specified time and then performs op with n.
+func (s *MemoryStore) Apply(id string, cfg Config, t time.Time, op Op, n int64) (Result, error) {
+	return s.getOrCreate(id, cfg, nil, t).apply(t, op, n), nil
+}
+
+// Load returns the state of the bucket without refilling or creating it.
+func (s *MemoryStore) Load(id string) (State, bool, error) {
+	bucket, ok := s.get(id)
+	if !ok {
+		return State{}, false, nil
+	}
+
+	bucket.mux.RLock()
+	defer bucket.mux.RUnlock()
+	return bucket.state(), true, nil
+}
+
+// Save replaces the bucket with one created from the provided state.
+// The new bucket keeps the clock of the bucket it replaces.
+func (s *MemoryStore) Save(id string, st State) error {
+	var clock Clock
+	if old, ok := s.get(id); ok {
+		clock = old.Clock
+	}
+	s.set(id, newBucketFromState(st, clock))
+	return nil
+}
+
+// Delete removes the bucket.
+func (s *MemoryStore) Delete(id string) error {
+	s.bucketMux.Lock()
+	s.removeLocked(id)
+	s.bucketMux.Unlock()
+	return nil
+}
+
+// Purge removes every bucket which is fully refilled at the specified time.
+// As with Sweep, the buckets are removed in small batches, and are not
+// marked as used by being checked.
+func (s *MemoryStore) Purge(t time.Time) (int, error) {
+	return s.Sweep(t, 0)
+}
+
+// sweepBatch is the number of buckets removed by Sweep each time it takes the write lock.
+const sweepBatch = 128
+
+// Sweep removes every bucket which is fully refilled at the specified time,
+// or which has not been used for longer than idle, if idle is positive.
+//
+// Buckets are checked without holding the store's write lock, which is then
+// only taken to remove the expired buckets in small batches. Each bucket is
+// checked again before it is removed, in case it was used in the meantime.
+func (s *MemoryStore) Sweep(t time.Time, idle time.Duration) (int, error) {
+	expired := func(bucket *Bucket) bool {
+		bucket.mux.Lock()
+		defer bucket.mux.Unlock()
+
+		if idle > 0 && t.Sub(bucket.lastAccess) > idle {
+			return true
+		}
+		return bucket.fullAt(t)
+	}
+
+	var candidates []string
+	s.Range(func(id string, _ State) bool {
+		if bucket, ok := s.get(id); ok && expired(bucket) {
+			candidates = append(candidates, id)
+		}
+		return true
+	})
+
+	removed := 0
+	for len(candidates) > 0 {
+		batch := candidates
+		if len(batch) > sweepBatch {
+			batch = batch[:sweepBatch]
+		}
+		candidates = candidates[len(batch):]
+
+		s.bucketMux.Lock()
+		for _, id := range batch {
+			if bucket, ok := s.buckets[id]; ok && expired(bucket) {
+				s.removeLocked(id)
+				removed++
+			}
+		}
+		s.bucketMux.Unlock()
+	}
+	return removed, nil
+}
+
+// Range calls fn with the state of each bucket until fn returns false.
+//
+// The store is not locked while fn is called, so fn may use the store.
+func (s *MemoryStore) Range(fn func(id string, st State) bool) error {
+	s.bucketMux.RLock()
+	buckets := make(map[string]*Bucket, len(s.buckets))
+	for id, bucket := range s.buckets {
+		buckets[id] = bucket
+	}
+	s.bucketMux.RUnlock()
+
+	for id, bucket := range buckets {
+		bucket.mux.RLock()
+		st := bucket.state()
+		bucket.mux.RUnlock()
+
+		if !fn(id, st) {
+			break
+		}
+	}
+	return nil
+}
+
+// Len returns the number of buckets held by the store.
+func (s *MemoryStore) Len() int {
+	s.bucketMux.RLock()
+	defer s.bucketMux.RUnlock()
+	return len(s.buckets)
+}
+
+// Evictions returns the total number of buckets removed to make room for another.
+func (s *MemoryStore) Evictions() int64 {
+	return atomic.LoadInt64(&s.evictions)
+}
+
+// bucket returns the live Bucket for the id, creating it with the clock if necessary.
+func (s *MemoryStore) bucket(id string, cfg Config, clock Clock) *Bucket {
+	return s.getOrCreate(id, cfg, clock, clockOrReal(clock).Now())
+}
+
+// setBucket stores a live Bucket under the id.
+func (s *MemoryStore) setBucket(id string, bucket *Bucket) {
+	s.set(id, bucket)
+}
+
+// getOrCreate returns the bucket, creating it if necessary. The bucket is
+// checked for again once the write lock is held, so that concurrent calls
+// for a new id all return the same bucket rather than overwriting it.
+//
+// New buckets are given the clock. If the store is bounded, the bucket is marked
+// as the most recently used, and t is the time used to find full buckets to evict.
+func (s *MemoryStore) getOrCreate(id string, cfg Config, clock Clock, t time.Time) *Bucket {
+	if s.MaxBuckets <= 0 {
+		if bucket, ok := s.get(id); ok {
+			return bucket
+		}
+	}
+
+	s.bucketMux.Lock()
+	if bucket, ok := s.buckets[id]; ok {
+		s.touchLocked(id)
+		s.bucketMux.Unlock()
+		return bucket
+	}
+
+	bucket := NewBucket(cfg.Limit, cfg.Burst, cfg.Refill)
+	bucket.Clock = clock
+	evicted := s.insertLocked(id, bucket, t)
+	s.bucketMux.Unlock()
+
+	s.notify(evicted)
+	return bucket
+}
+
+func (s *MemoryStore) get(id string) (*Bucket, bool) {
+	s.bucketMux.RLock()
+	bucket, ok := s.buckets[id]
+	s.bucketMux.RUnlock()
+	return bucket, ok
+}
+
+func (s *MemoryStore) set(id string, bucket *Bucket) {
+	s.bucketMux.Lock()
+	evicted := s.insertLocked(id, bucket, bucket.now())
+	s.bucketMux.Unlock()
+
+	s.notify(evicted)
+}
+
+// evicted is a bucket which was removed to make room for another.
+type evicted struct {
+	id     string
+	bucket *Bucket
+}
+
+// insertLocked adds or replaces a bucket, evicting others if the store is full.
+//
+// the store must be write-locked for the duration of the call.
+func (s *MemoryStore) insertLocked(id string, bucket *Bucket, t time.Time) []evicted {
+	s.buckets[id] = bucket
+	if s.MaxBuckets <= 0 {
+		return nil
+	}
+
+	s.touchLocked(id)
+
+	var removed []evicted
+	for len(s.buckets) > s.MaxBuckets {
+		victim := s.victimLocked(id, t)
+		removed = append(removed, evicted{victim, s.buckets[victim]})
+		s.removeLocked(victim)
+		atomic.AddInt64(&s.evictions, 1)
+	}
+	return removed
+}
+
+// victimLocked selects the bucket to evict, which is never the bucket being inserted.
+//
+// the store must be write-locked for the duration of the call.
+func (s *MemoryStore) victimLocked(inserted string, t time.Time) string {
+	if s.Eviction == EvictFullest {
+		for e := s.recent.Back(); e != nil; e = e.Prev() {
+			id := e.Value.(string)
+			if id == inserted {
+				continue
+			}
+
+			bucket := s.buckets[id]
+			bucket.mux.Lock()
+			full := bucket.fullAt(t)
+			bucket.mux.Unlock()
+			if full {
+				return id
+			}
+		}
+	}
+
+	back := s.recent.Back()
+	if back.Value.(string) == inserted {
+		back = back.Prev()
+	}
+	return back.Value.(string)
+}
+
+// touchLocked marks the bucket as the most recently used, if the store is bounded.
+//
+// the store must be write-locked for the duration of the call.
+func (s *MemoryStore) touchLocked(id string) {
+	if s.MaxBuckets <= 0 {
+		return
+	}
+	if e, ok := s.elems[id]; ok {
+		s.recent.MoveToFront(e)
+		return
+	}
+	s.elems[id] = s.recent.PushFront(id)
+}
+
+// removeLocked removes a bucket.
+//
+// the store must be write-locked for the duration of the call.
+func (s *MemoryStore) removeLocked(id string) {
+	delete(s.buckets, id)
+	if e, ok := s.elems[id]; ok {
+		s.recent.Remove(e)
+		delete(s.elems, id)
+	}
+}
+
+// notify calls OnEvict for each evicted bucket, outside of the store's lock.
+func (s *MemoryStore) notify(removed []evicted) {
+	if s.OnEvict == nil {
+		return
+	}
+	for _, e := range removed {
+		s.OnEvict(e.id, e.bucket)
+	}
+}
@@ -0,0 +1,107 @@
+package gorl
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStore_EvictLRU(t *testing.T) {
+	now := time.Now()
+	bm := NewBounded(5, 20, time.Second, 2)
+	store := bm.Store().(*MemoryStore)
+
+	var evictedIDs []string
+	store.OnEvict = func(id string, bucket *Bucket) {
+		evictedIDs = append(evictedIDs, id)
+	}
+
+	bm.DrawAt("a", now, 1)
+	bm.DrawAt("b", now, 1)
+	bm.DrawAt("a", now, 1) // a is now more recently used than b
+	bm.DrawAt("c", now, 1) // evicts b
+
+	if len(evictedIDs) != 1 || evictedIDs[0] != "b" {
+		t.Error("expected the least recently used bucket to be evicted, got", evictedIDs)
+	}
+	if store.Len() != 2 {
+		t.Error("expected the store to hold 2 buckets, got", store.Len())
+	}
+	if tokens := bm.TokensAt("a", now); tokens != 18 {
+		t.Error("expected bucket a to be kept with 18 tokens, got", tokens)
+	}
+
+	bm.DrawAt("d", now, 1) // evicts c, since a was just used
+	if store.Evictions() != 2 || evictedIDs[1] != "c" {
+		t.Error("expected c to be the second eviction, got", evictedIDs)
+	}
+}
+
+func TestMemoryStore_EvictFullest(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryStore()
+	store.MaxBuckets = 3
+	store.Eviction = EvictFullest
+	bm := NewWithStore(5, 20, time.Second, store)
+
+	bm.DrawAt("drained", now, 20)
+	bm.DrawAt("full", now, 0)
+	bm.DrawAt("used", now, 5)
+	bm.DrawAt("new", now, 1)
+
+	if _, ok := store.get("full"); ok {
+		t.Error("expected the full bucket to be evicted first")
+	}
+	if _, ok := store.get("drained"); !ok {
+		t.Error("expected the drained bucket to be kept, despite being least recently used")
+	}
+
+	// with no full buckets, falls back to the least recently used
+	bm.DrawAt("newer", now, 1)
+	if _, ok := store.get("drained"); ok {
+		t.Error("expected the least recently used bucket to be evicted when none are full")
+	}
+}
+
+func TestMemoryStore_Unbounded(t *testing.T) {
+	bm := New(5, 20, time.Second)
+	store := bm.Store().(*MemoryStore)
+
+	for i := 0; i < 100; i++ {
+		bm.Draw(string(rune('a'+i)), 1)
+	}
+	if store.Len() != 100 || store.Evictions() != 0 {
+		t.Errorf("expected an unbounded store to hold every bucket, have %d", store.Len())
+	}
+	if store.recent.Len() != 0 {
+		t.Error("expected an unbounded store to not track usage order")
+	}
+}
+
+func TestMemoryStore_SaveKeepsClock(t *testing.T) {
+	clock := realClock{}
+	store := NewMemoryStore()
+	store.bucket(id, Config{Limit: 5, Burst: 20, Refill: time.Second}, clock)
+
+	st := State{Config: Config{Limit: 1, Burst: 10, Refill: time.Minute}, Tokens: 3, LastUpdate: time.Now()}
+	if err := store.Save(id, st); err != nil {
+		t.Fatal("unexpected error from Save:", err)
+	}
+
+	bucket, _ := store.get(id)
+	if bucket.Clock != clock || bucket.Limit != 1 || bucket.tokens != 3 {
+		t.Error("expected saved bucket to have the new state and keep its clock")
+	}
+}
+
+func TestMemoryStore_PurgeKeepsIdle(t *testing.T) {
+	now := time.Now()
+	bm := New(1, 20, time.Hour)
+	bm.IdleTTL = time.Minute
+	bm.DrawAt("a", now, 5)
+
+	// purging checks the bucket, which must not count as using it.
+	bm.Store().Purge(now.Add(30 * time.Second))
+	if removed := bm.SweepAt(now.Add(61 * time.Second)); removed != 1 {
+		t.Error("expected bucket to be swept once idle, removed", removed)
+	}
+}
@@ -0,0 +1,261 @@
+package gorl
+
+import (
+	"sync"
+	"time"
+)
+
+// Period is the length of the calendar windows used by a Quota.
+type Period int
+
+const (
+	// Daily windows start at midnight.
+	Daily Period = iota
+	// Weekly windows start at midnight on the quota's WeekStart day.
+	Weekly
+	// Monthly windows start at midnight on the first day of the month.
+	Monthly
+)
+
+// Quota is a thread-safe limiter which allows Limit requests per calendar day,
+// week or month in a time zone, such as "10,000 calls per calendar month". All
+// of the quota becomes available again at the start of each window.
+//
+// Windows follow the wall clock in Location, so days on which daylight saving
+// time starts or ends are 23 or 25 hours long, and months have their actual
+// number of days, unlike the fixed Refill interval of a Bucket.
+type Quota struct {
+	// Limit is the number of requests allowed in each window.
+	Limit int64
+	// Period is the length of each window.
+	Period Period
+	// Location is the time zone in which windows start at midnight.
+	// UTC is used if Location is nil.
+	Location *time.Location
+	// WeekStart is the day on which Weekly windows start.
+	WeekStart time.Weekday
+	// Clock provides the current time for the non-At methods.
+	// RealClock is used if Clock is nil.
+	Clock Clock
+
+	end  time.Time // end of the current window
+	used int64
+	mux  sync.Mutex
+}
+
+// NewQuota creates a new Quota with windows starting at midnight in the location.
+func NewQuota(limit int64, period Period, loc *time.Location) *Quota {
+	return &Quota{
+		Limit:    limit,
+		Period:   period,
+		Location: loc,
+	}
+}
+
+// NewQuotaFactory returns a factory for NewManager which creates
+// quotas with the provided parameters, such as for BucketManager.Quotas.
+func NewQuotaFactory(limit int64, period Period, loc *time.Location) func(id string) *Quota {
+	return func(string) *Quota {
+		return NewQuota(limit, period, loc)
+	}
+}
+
+// CanDraw returns whether n more requests are allowed in the current window.
+func (q *Quota) CanDraw(n int64) bool {
+	return q.CanDrawAt(q.now(), n)
+}
+
+// CanDrawAt returns whether n more requests are allowed in the window containing the specified time.
+func (q *Quota) CanDrawAt(t time.Time, n int64) bool {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.advance(t)
+
+	return q.used+n <= q.Limit
+}
+
+// Draw records n requests, returning whether they were allowed. If not, nothing is recorded.
+func (q *Quota) Draw(n int64) bool {
+	return q.DrawAt(q.now(), n)
+}
+
+// DrawAt records n requests at the specified time, returning whether they
+// were allowed. If not, nothing is recorded.
+func (q *Quota) DrawAt(t time.Time, n int64) bool {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.advance(t)
+
+	if q.used+n > q.Limit {
+		return false
+	}
+	q.used += n
+	return true
+}
+
+// ForceDraw records n requests, even if they exceed the limit.
+func (q *Quota) ForceDraw(n int64) {
+	q.ForceDrawAt(q.now(), n)
+}
+
+// ForceDrawAt records n requests at the specified time, even if they exceed the limit.
+// The excess is not carried over to the next window.
+func (q *Quota) ForceDrawAt(t time.Time, n int64) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.advance(t)
+
+	q.used += n
+}
+
+// Remaining returns the number of requests allowed in the current window.
+func (q *Quota) Remaining() int64 {
+	return q.RemainingAt(q.now())
+}
+
+// RemainingAt returns the number of requests allowed in the window containing the specified time.
+func (q *Quota) RemainingAt(t time.Time) int64 {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.advance(t)
+
+	if q.used > q.Limit {
+		return 0
+	}
+	return q.Limit - q.used
+}
+
+// NextRefill returns the start of the next window.
+func (q *Quota) NextRefill() time.Time {
+	return q.NextRefillAt(q.now())
+}
+
+// NextRefillAt returns the start of the window after the one containing the specified time.
+func (q *Quota) NextRefillAt(t time.Time) time.Time {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.advance(t)
+
+	return q.end
+}
+
+// Reset removes every request recorded in the current window.
+func (q *Quota) Reset() {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	q.used = 0
+}
+
+// IsReset returns whether no requests have been recorded in the current window.
+func (q *Quota) IsReset() bool {
+	return q.IsResetAt(q.now())
+}
+
+// IsResetAt returns whether no requests have been recorded in the window containing the specified time.
+func (q *Quota) IsResetAt(t time.Time) bool {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.advance(t)
+
+	return q.used == 0
+}
+
+// Allow records one request, returning whether it was allowed.
+func (q *Quota) Allow() bool {
+	return q.Draw(1)
+}
+
+// AllowN records n requests, returning whether they were allowed. It is the same as Draw.
+func (q *Quota) AllowN(n int64) bool {
+	return q.Draw(n)
+}
+
+// RetryAfter returns how long until n more requests will be allowed.
+func (q *Quota) RetryAfter(n int64) time.Duration {
+	return q.RetryAfterAt(q.now(), n)
+}
+
+// RetryAfterAt returns how long after the specified time until n more requests will
+// be allowed, which is zero if they are allowed now, or InfDuration if n exceeds Limit.
+func (q *Quota) RetryAfterAt(t time.Time, n int64) time.Duration {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.advance(t)
+
+	switch {
+	case n > q.Limit:
+		return InfDuration
+	case q.used+n <= q.Limit:
+		return 0
+	}
+	return q.end.Sub(t)
+}
+
+// drawWith draws n requests from the quota if draw also succeeds, so that
+// requests denied by another limiter are not counted against the quota.
+func (q *Quota) drawWith(t time.Time, n int64, draw func() bool) bool {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.advance(t)
+
+	if q.used+n > q.Limit || !draw() {
+		return false
+	}
+	q.used += n
+	return true
+}
+
+// drawMaxWith draws up to n requests from the quota, limited to the number
+// which drawMax is able to draw from another limiter, and returns that number.
+func (q *Quota) drawMaxWith(t time.Time, n int64, drawMax func(n int64) int64) int64 {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.advance(t)
+
+	if remaining := q.Limit - q.used; n > remaining {
+		n = remaining
+	}
+	if n <= 0 {
+		return 0
+	}
+	drawn := drawMax(n)
+	q.used += drawn
+	return drawn
+}
+
+// now returns the current time from the limiter's clock.
+func (q *Quota) now() time.Time {
+	return clockOrReal(q.Clock).Now()
+}
+
+// advance starts a new window if the specified time is after the end of the current one.
+//
+// the limiter must be locked for the duration of the call.
+func (q *Quota) advance(t time.Time) {
+	if t.Before(q.end) {
+		return
+	}
+	q.end = q.windowEnd(t)
+	q.used = 0
+}
+
+// windowEnd returns the end of the window containing the specified time, which is
+// found using the wall clock date so that daylight saving time is handled correctly.
+func (q *Quota) windowEnd(t time.Time) time.Time {
+	loc := q.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	year, month, day := t.In(loc).Date()
+	switch q.Period {
+	case Weekly:
+		offset := (int(t.In(loc).Weekday()) - int(q.WeekStart) + 7) % 7
+		return time.Date(year, month, day-offset+7, 0, 0, 0, 0, loc)
+	case Monthly:
+		return time.Date(year, month+1, 1, 0, 0, 0, 0, loc)
+	default:
+		return time.Date(year, month, day+1, 0, 0, 0, 0, loc)
+	}
+}
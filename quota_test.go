@@ -0,0 +1,160 @@
+package gorl
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestQuota_Daily(t *testing.T) {
+	loc := mustLoadLocation(t, "Asia/Tokyo")
+	q := NewQuota(10, Daily, loc)
+
+	now := time.Date(2024, 5, 1, 23, 0, 0, 0, loc)
+	if !q.DrawAt(now, 10) {
+		t.Error("expected to be able to draw 10 requests")
+	}
+	if q.DrawAt(now.Add(59*time.Minute), 1) {
+		t.Error("expected to NOT be able to draw before midnight")
+	}
+
+	midnight := time.Date(2024, 5, 2, 0, 0, 0, 0, loc)
+	if next := q.NextRefillAt(now); !next.Equal(midnight) {
+		t.Error("expected next refill at midnight, got", next)
+	}
+	if d := q.RetryAfterAt(now, 1); d != time.Hour {
+		t.Error("expected retry after 1h, got", d)
+	}
+	if remaining := q.RemainingAt(midnight); remaining != 10 {
+		t.Error("expected remaining to be 10 at midnight, got", remaining)
+	}
+}
+
+func TestQuota_DaylightSaving(t *testing.T) {
+	loc := mustLoadLocation(t, "America/New_York")
+	q := NewQuota(1, Daily, loc)
+
+	// clocks go forward on 2024-03-10, so the day is 23 hours long
+	start := time.Date(2024, 3, 10, 0, 30, 0, 0, loc)
+	q.DrawAt(start, 1)
+	next := q.NextRefillAt(start)
+	if want := time.Date(2024, 3, 11, 0, 0, 0, 0, loc); !next.Equal(want) {
+		t.Error("expected next refill at", want, "got", next)
+	}
+	if d := next.Sub(start); d != 22*time.Hour+30*time.Minute {
+		t.Error("expected 22h30m until the next refill, got", d)
+	}
+
+	// clocks go back on 2024-11-03, so the day is 25 hours long
+	start = time.Date(2024, 11, 3, 0, 0, 0, 0, loc)
+	q.DrawAt(start, 1)
+	if q.CanDrawAt(start.Add(24*time.Hour), 1) {
+		t.Error("expected to NOT be able to draw 24 hours into a 25 hour day")
+	}
+	if !q.CanDrawAt(start.Add(25*time.Hour), 1) {
+		t.Error("expected to be able to draw 25 hours into a 25 hour day")
+	}
+}
+
+func TestQuota_Weekly(t *testing.T) {
+	q := NewQuota(5, Weekly, time.UTC)
+	q.WeekStart = time.Monday
+
+	// 2024-05-01 is a Wednesday
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	q.DrawAt(now, 5)
+	if q.CanDrawAt(time.Date(2024, 5, 5, 23, 59, 0, 0, time.UTC), 1) {
+		t.Error("expected to NOT be able to draw on Sunday")
+	}
+	if next := q.NextRefillAt(now); !next.Equal(time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)) {
+		t.Error("expected next refill on Monday, got", next)
+	}
+}
+
+func TestQuota_Monthly(t *testing.T) {
+	loc := mustLoadLocation(t, "Europe/Berlin")
+	q := NewQuota(100, Monthly, loc)
+
+	now := time.Date(2024, 2, 29, 12, 0, 0, 0, loc)
+	q.ForceDrawAt(now, 150)
+	if remaining := q.RemainingAt(now); remaining != 0 {
+		t.Error("expected remaining to be 0, got", remaining)
+	}
+	if d := q.RetryAfterAt(now, 101); d != InfDuration {
+		t.Error("expected retry after to be infinite, got", d)
+	}
+
+	march := time.Date(2024, 3, 1, 0, 0, 0, 0, loc)
+	if next := q.NextRefillAt(now); !next.Equal(march) {
+		t.Error("expected next refill on March 1st, got", next)
+	}
+	if !q.IsResetAt(march) {
+		t.Error("expected quota to be reset in March")
+	}
+}
+
+func TestBucketManager_Quotas(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	bm := New(5, 5, time.Second)
+	bm.Quotas = NewManager(NewQuotaFactory(12, Daily, time.UTC))
+
+	// the bucket denies the 6th request, which is not counted against the quota
+	if drawn := bm.DrawMaxAt(id, now, 6); drawn != 5 {
+		t.Error("expected to draw 5 requests, got", drawn)
+	}
+	if bm.DrawAt(id, now, 1) {
+		t.Error("expected bucket to deny the draw")
+	}
+	if remaining := bm.Quotas.Get(id).RemainingAt(now); remaining != 7 {
+		t.Error("expected quota remaining to be 7, got", remaining)
+	}
+
+	now = now.Add(2 * time.Second)
+	if !bm.DrawAt(id, now, 5) {
+		t.Error("expected to be able to draw 5 requests")
+	}
+
+	// the quota has 2 left, although the bucket has refilled
+	now = now.Add(time.Second)
+	if remaining := bm.RemainingAt(id, now); remaining != 2 {
+		t.Error("expected remaining to be 2, got", remaining)
+	}
+	if bm.DrawAt(id, now, 3) {
+		t.Error("expected quota to deny the draw")
+	}
+	if tokens := bm.TokensAt(id, now); tokens != 5 {
+		t.Error("expected bucket to keep 5 tokens, got", tokens)
+	}
+	if d := bm.RetryAfterAt(id, now, 3); d != 11*time.Hour+59*time.Minute+57*time.Second {
+		t.Error("expected retry after until midnight, got", d)
+	}
+	if drawn := bm.DrawMaxAt(id, now, 5); drawn != 2 {
+		t.Error("expected to draw 2 requests, got", drawn)
+	}
+}
+
+func TestBucketManager_SweepQuotas(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	bm := New(5, 5, time.Second)
+	bm.Quotas = NewManager(NewQuotaFactory(12, Daily, time.UTC))
+	bm.DrawAt("a", now, 1)
+	bm.RemainingAt("b", now)
+
+	// b has drawn nothing, while a is kept until its window ends
+	bm.SweepAt(now)
+	if n := bm.Quotas.Len(); n != 1 {
+		t.Error("expected only the used quota to be kept, have", n)
+	}
+	bm.SweepAt(now.Add(12 * time.Hour))
+	if n := bm.Quotas.Len(); n != 0 {
+		t.Error("expected quotas whose window has ended to be swept, have", n)
+	}
+}
@@ -0,0 +1,196 @@
+package gorl
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rate is the set of parameters of a bucket, which can be parsed
+// from a human-friendly string using ParseRate.
+type Rate struct {
+	// Limit is the number of tokens added back to the bucket each Refill.
+	Limit int64
+	// Burst is the number of tokens which the bucket can hold.
+	Burst int64
+	// Refill is the interval at which tokens are added back to the bucket.
+	Refill time.Duration
+}
+
+// units are the interval names accepted by ParseRate, other than those of time.ParseDuration.
+var units = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "second": time.Second, "seconds": time.Second,
+	"m": time.Minute, "min": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+}
+
+// ParseRate parses a rate such as "100/s", "5k/1h", "10 per minute" or
+// "100/1m burst 200".
+//
+// The limit may have a k or m suffix for thousands or millions. The interval
+// is a time.Duration such as "1m30s", or a number and unit such as "5m" or
+// "2 days", where the number defaults to 1. The burst is given after the
+// interval as "burst n", optionally separated by a comma, and defaults to
+// the limit. The format of Rate.String is also accepted.
+func ParseRate(s string) (Rate, error) {
+	var r Rate
+
+	rate, burst, hasBurst := cutBurst(s)
+	limit, interval, ok := strings.Cut(rate, "/")
+	if !ok {
+		limit, interval, ok = strings.Cut(rate, " per ")
+	}
+	if !ok {
+		return r, fmt.Errorf("gorl: invalid rate %q: expected \"<limit>/<interval>\" or \"<limit> per <interval>\"", s)
+	}
+
+	var err error
+	if r.Limit, err = parseCount(strings.TrimSpace(limit)); err != nil {
+		return r, fmt.Errorf("gorl: invalid rate %q: invalid limit %q", s, strings.TrimSpace(limit))
+	}
+	if r.Refill, err = parseInterval(strings.TrimSpace(interval)); err != nil {
+		return r, fmt.Errorf("gorl: invalid rate %q: invalid interval %q", s, strings.TrimSpace(interval))
+	}
+	r.Burst = r.Limit
+	if hasBurst {
+		if r.Burst, err = parseCount(burst); err != nil {
+			return r, fmt.Errorf("gorl: invalid rate %q: invalid burst %q", s, burst)
+		}
+	}
+
+	if err := r.Config().Validate(); err != nil {
+		return r, fmt.Errorf("gorl: invalid rate %q: %w", s, err)
+	}
+	return r, nil
+}
+
+// MustParseRate is like ParseRate, but panics if the rate can not be parsed.
+// It is intended for rates which are constants in the program.
+func MustParseRate(s string) Rate {
+	r, err := ParseRate(s)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Config returns the rate as a Config.
+func (r Rate) Config() Config {
+	return Config{
+		Limit:  r.Limit,
+		Burst:  r.Burst,
+		Refill: r.Refill,
+	}
+}
+
+// Bucket creates a new Bucket with the rate.
+func (r Rate) Bucket() *Bucket {
+	return NewBucket(r.Limit, r.Burst, r.Refill)
+}
+
+// Manager creates a new BucketManager which creates buckets with the rate.
+func (r Rate) Manager() *BucketManager {
+	return New(r.Limit, r.Burst, r.Refill)
+}
+
+// String formats the rate like "5 per 1s, burst 20", which ParseRate accepts.
+func (r Rate) String() string {
+	return fmt.Sprintf("%d per %s, burst %d", r.Limit, formatInterval(r.Refill), r.Burst)
+}
+
+// String formats the parameters of the bucket like "5 per 1s, burst 20".
+func (b *Bucket) String() string {
+	cfg := b.Config()
+	return Rate{Limit: cfg.Limit, Burst: cfg.Burst, Refill: cfg.Refill}.String()
+}
+
+// String formats the parameters used to create new buckets like "5 per 1s, burst 20".
+func (m *BucketManager) String() string {
+	m.configMux.RLock()
+	defer m.configMux.RUnlock()
+	return Rate{Limit: m.Limit, Burst: m.Burst, Refill: m.Refill}.String()
+}
+
+// cutBurst splits a trailing "burst n" or ", burst n" from the rate.
+func cutBurst(s string) (rate, burst string, ok bool) {
+	i := strings.LastIndex(s, "burst")
+	if i < 0 {
+		return s, "", false
+	}
+	rate = strings.TrimRight(strings.TrimSpace(s[:i]), ",")
+	return rate, strings.TrimSpace(s[i+len("burst"):]), true
+}
+
+// parseCount parses a positive count with an optional k or m suffix.
+func parseCount(s string) (int64, error) {
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "k"), strings.HasSuffix(s, "K"):
+		multiplier = 1000
+	case strings.HasSuffix(s, "m"), strings.HasSuffix(s, "M"):
+		multiplier = 1000000
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n > math.MaxInt64/multiplier || n < math.MinInt64/multiplier {
+		return 0, fmt.Errorf("count %q is out of range", s)
+	}
+	return n * multiplier, nil
+}
+
+// parseInterval parses an interval such as "1m30s", "5m", "minute" or "2 days".
+func parseInterval(s string) (time.Duration, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, nil
+	}
+
+	// split the number from the unit, which may be separated by a space.
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return r < '0' || r > '9'
+	})
+	if i < 0 {
+		return 0, fmt.Errorf("missing unit in %q", s)
+	}
+	number, unit := s[:i], strings.TrimSpace(s[i:])
+
+	unitDuration, ok := units[strings.ToLower(unit)]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", unit)
+	}
+	if number == "" {
+		return unitDuration, nil
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n > math.MaxInt64/int64(unitDuration) {
+		return 0, fmt.Errorf("interval %q is out of range", s)
+	}
+	return time.Duration(n) * unitDuration, nil
+}
+
+// formatInterval formats an interval using its largest whole unit, such as
+// "1m" rather than "1m0s", falling back to time.Duration.String.
+func formatInterval(d time.Duration) string {
+	switch {
+	case d <= 0:
+		return d.String()
+	case d%(24*time.Hour) == 0:
+		return strconv.FormatInt(int64(d/(24*time.Hour)), 10) + "d"
+	case d%time.Hour == 0:
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "h"
+	case d%time.Minute == 0:
+		return strconv.FormatInt(int64(d/time.Minute), 10) + "m"
+	}
+	return d.String()
+}
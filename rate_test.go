@@ -0,0 +1,86 @@
+package gorl
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	for _, tc := range []struct {
+		rate string
+		want Rate
+	}{
+		{"100/s", Rate{100, 100, time.Second}},
+		{"5k/1h", Rate{5000, 5000, time.Hour}},
+		{"10 per minute", Rate{10, 10, time.Minute}},
+		{"10 per 5 minutes", Rate{10, 10, 5 * time.Minute}},
+		{"100/1m burst 200", Rate{100, 200, time.Minute}},
+		{"1M/2d", Rate{1000000, 1000000, 48 * time.Hour}},
+		{"3/1m30s", Rate{3, 3, 90 * time.Second}},
+		{"5 per 1s, burst 20", Rate{5, 20, time.Second}},
+	} {
+		r, err := ParseRate(tc.rate)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", tc.rate, err)
+			continue
+		}
+		if r != tc.want {
+			t.Errorf("expected %q to parse as %v, got %v", tc.rate, tc.want, r)
+		}
+	}
+
+	for _, rate := range []string{"", "10", "x/1s", "10/x", "10/1s burst", "10/1s burst 0", "0/1s", "10/-1s", "10 per fortnight"} {
+		if _, err := ParseRate(rate); err == nil {
+			t.Errorf("expected an error for %q", rate)
+		}
+	}
+}
+
+func TestRate_String(t *testing.T) {
+	for _, tc := range []struct {
+		rate Rate
+		want string
+	}{
+		{Rate{5, 20, time.Second}, "5 per 1s, burst 20"},
+		{Rate{100, 100, time.Minute}, "100 per 1m, burst 100"},
+		{Rate{1, 1, 36 * time.Hour}, "1 per 36h, burst 1"},
+		{Rate{1, 1, 48 * time.Hour}, "1 per 2d, burst 1"},
+		{Rate{1, 1, 1500 * time.Millisecond}, "1 per 1.5s, burst 1"},
+	} {
+		if got := tc.rate.String(); got != tc.want {
+			t.Errorf("expected %q, got %q", tc.want, got)
+		}
+		if r, err := ParseRate(tc.rate.String()); err != nil || r != tc.rate {
+			t.Errorf("expected %q to parse as %v, got %v (%v)", tc.want, tc.rate, r, err)
+		}
+	}
+
+	r := MustParseRate("5/s burst 20")
+	if got := r.Bucket().String(); got != "5 per 1s, burst 20" {
+		t.Error("expected bucket to format as 5 per 1s, burst 20, got", got)
+	}
+	if got := r.Manager().String(); got != "5 per 1s, burst 20" {
+		t.Error("expected manager to format as 5 per 1s, burst 20, got", got)
+	}
+}
+
+func TestParseCountOverflow(t *testing.T) {
+	for _, s := range []string{"9223372036854776k", "-9223372036854776k", "10000000000000M"} {
+		if n, err := parseCount(s); err == nil {
+			t.Errorf("expected an error for %q, got %d", s, n)
+		}
+	}
+	if n, err := parseCount("9223372036854775k"); err != nil || n != 9223372036854775000 {
+		t.Error("expected the largest count to parse, got", n, err)
+	}
+
+	for _, s := range []string{"300000d", "1000000 days"} {
+		if d, err := parseInterval(s); err == nil || !strings.Contains(err.Error(), "out of range") {
+			t.Errorf("expected an out of range error for %q, got %s (%v)", s, d, err)
+		}
+		if r, err := ParseRate("1/" + s); err == nil || !strings.Contains(err.Error(), "invalid interval") {
+			t.Errorf("expected an invalid interval error for %q, got %v (%v)", s, r, err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package gorl
+
+import "time"
+
+// TokenPolicy determines what happens to the tokens in a bucket when it is reconfigured.
+type TokenPolicy int
+
+const (
+	// KeepTokens keeps the number of tokens, capped at the new burst quantity.
+	KeepTokens TokenPolicy = iota
+	// ClampTokens keeps the number of tokens, clamped between zero and the new
+	// burst quantity, so any overdraft made using ForceDraw or SetTokens is forgiven.
+	ClampTokens
+	// ScaleTokens scales the number of tokens in proportion to the new burst
+	// quantity, so a bucket which was half full remains half full.
+	ScaleTokens
+)
+
+// Reconfigure changes the parameters of the bucket, applying the policy to the
+// number of tokens. Unlike setting the exported fields directly, it is safe to
+// call while the bucket is in use. If cfg is invalid, the error from
+// Config.Validate is returned and the bucket is not changed.
+func (b *Bucket) Reconfigure(cfg Config, policy TokenPolicy) error {
+	return b.ReconfigureAt(b.now(), cfg, policy)
+}
+
+// ReconfigureAt changes the parameters of the bucket at the specified time,
+// applying the policy to the number of tokens. The bucket is refilled using
+// the old parameters first, and the next refill is one new Refill interval
+// after the specified time. If cfg is invalid, the error from Config.Validate
+// is returned and the bucket is not changed.
+func (b *Bucket) ReconfigureAt(t time.Time, cfg Config, policy TokenPolicy) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.refill(t)
+
+	switch policy {
+	case ClampTokens:
+		if b.tokens < 0 {
+			b.tokens = 0
+		}
+	case ScaleTokens:
+		if b.Burst > 0 {
+			b.tokens = b.tokens * cfg.Burst / b.Burst
+		} else {
+			b.tokens = cfg.Burst
+		}
+	}
+	b.tokens = min(b.tokens, cfg.Burst)
+
+	b.Limit = cfg.Limit
+	b.Burst = cfg.Burst
+	b.Refill = cfg.Refill
+	b.lastUpdate = t
+	return nil
+}
+
+// Reconfigure changes the parameters used to create new buckets, and applies
+// them to every existing bucket using the policy, such as to lower the limits
+// during an incident without restarting.
+//
+// If Resolver is set, each existing bucket is given the config which it
+// returns for the id instead, as with Migrate.
+func (m *BucketManager) Reconfigure(cfg Config, policy TokenPolicy) error {
+	return m.ReconfigureAt(m.now(), cfg, policy)
+}
+
+// ReconfigureAt changes the parameters used to create new buckets, and applies
+// them to every existing bucket at the specified time using the policy. If cfg
+// is invalid, the error from Config.Validate is returned and nothing is changed.
+//
+// Errors from the store, or from invalid configs returned by Resolver, are
+// passed to ErrorHandler, and the first one is returned once every other
+// bucket has been reconfigured.
+//
+// If the store does not hold Bucket instances in memory, each bucket is
+// loaded and saved again, so draws made in between may be lost.
+func (m *BucketManager) ReconfigureAt(t time.Time, cfg Config, policy TokenPolicy) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	m.configMux.Lock()
+	m.Limit = cfg.Limit
+	m.Burst = cfg.Burst
+	m.Refill = cfg.Refill
+	m.configMux.Unlock()
+
+	var ids []string
+	err := m.store.Range(func(id string, _ State) bool {
+		ids = append(ids, id)
+		return true
+	})
+	m.handleError("", err)
+
+	for _, id := range ids {
+		if bucketErr := m.reconfigureBucket(id, t, m.Config(id), policy); err == nil {
+			err = bucketErr
+		}
+	}
+	return err
+}
+
+// reconfigureBucket applies cfg to the existing bucket with the id using the
+// policy. Any error is passed to ErrorHandler before it is returned.
+func (m *BucketManager) reconfigureBucket(id string, t time.Time, cfg Config, policy TokenPolicy) error {
+	if err := cfg.Validate(); err != nil {
+		m.handleError(id, err)
+		return err
+	}
+	if s, ok := m.store.(bucketStore); ok {
+		return s.bucket(id, cfg, m.Clock).ReconfigureAt(t, cfg, policy)
+	}
+
+	state, ok, err := m.store.Load(id)
+	if err != nil || !ok {
+		m.handleError(id, err)
+		return err
+	}
+	b := newBucketFromState(state, m.Clock)
+	b.ReconfigureAt(t, cfg, policy)
+	err = m.store.Save(id, b.state())
+	m.handleError(id, err)
+	return err
+}
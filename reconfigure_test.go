@@ -0,0 +1,115 @@
+package gorl
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBucket_Reconfigure(t *testing.T) {
+	now := time.Now()
+	cfg := Config{Limit: 1, Burst: 10, Refill: time.Minute}
+
+	b := NewBucket(5, 20, time.Second)
+	b.ForceDrawAt(now, 10)
+	b.ReconfigureAt(now, cfg, KeepTokens)
+	if tokens := b.TokensAt(now); tokens != 10 {
+		t.Error("expected to keep 10 tokens, got", tokens)
+	}
+
+	b = NewBucket(5, 20, time.Second)
+	b.ReconfigureAt(now, cfg, KeepTokens)
+	if tokens := b.TokensAt(now); tokens != 10 {
+		t.Error("expected tokens to be capped at the new burst, got", tokens)
+	}
+
+	b = NewBucket(5, 20, time.Second)
+	b.ForceDrawAt(now, 30)
+	b.ReconfigureAt(now, cfg, ClampTokens)
+	if tokens := b.TokensAt(now); tokens != 0 {
+		t.Error("expected overdraft to be clamped to 0, got", tokens)
+	}
+
+	b = NewBucket(5, 20, time.Second)
+	b.ForceDrawAt(now, 15)
+	b.ReconfigureAt(now.Add(500*time.Millisecond), cfg, ScaleTokens)
+	if tokens := b.TokensAt(now); tokens != 2 {
+		t.Error("expected tokens to be scaled to 2, got", tokens)
+	}
+
+	// the next refill is anchored to the time of the reconfiguration
+	next := b.NextRefillAt(now.Add(time.Second))
+	if want := now.Add(500*time.Millisecond + time.Minute); !next.Equal(want) {
+		t.Error("expected next refill at", want, "got", next)
+	}
+}
+
+func TestBucketManager_Reconfigure(t *testing.T) {
+	now := time.Now()
+	bm := New(5, 20, time.Second)
+	bm.ForceDrawAt("a", now, 10)
+	bm.ForceDrawAt("b", now, 20)
+
+	bm.ReconfigureAt(now, Config{Limit: 1, Burst: 4, Refill: time.Second}, ScaleTokens)
+	if tokens := bm.TokensAt("a", now); tokens != 2 {
+		t.Error("expected bucket a to have 2 tokens, got", tokens)
+	}
+	if tokens := bm.TokensAt("b", now); tokens != 0 {
+		t.Error("expected bucket b to have 0 tokens, got", tokens)
+	}
+	if tokens := bm.TokensAt("c", now); tokens != 4 {
+		t.Error("expected new bucket to be created with the new burst, got", tokens)
+	}
+	if tokens := bm.TokensAt("b", now.Add(time.Second)); tokens != 1 {
+		t.Error("expected bucket b to refill at the new limit, got", tokens)
+	}
+}
+
+func TestReconfigure_Invalid(t *testing.T) {
+	now := time.Now()
+	invalid := Config{Limit: 0, Burst: 10, Refill: time.Minute}
+
+	b := NewBucket(5, 20, time.Second)
+	if err := b.ReconfigureAt(now, invalid, KeepTokens); !errors.Is(err, ErrInvalidLimit) {
+		t.Error("expected ErrInvalidLimit from the bucket, got", err)
+	}
+	if b.Limit != 5 {
+		t.Error("expected an invalid config to not change the bucket")
+	}
+
+	bm := New(5, 20, time.Second)
+	bm.DrawAt("a", now, 1)
+	if err := bm.ReconfigureAt(now, invalid, KeepTokens); !errors.Is(err, ErrInvalidLimit) {
+		t.Error("expected ErrInvalidLimit from the manager, got", err)
+	}
+	if cfg := bm.Config("a"); cfg.Limit != 5 {
+		t.Error("expected an invalid config to not change the manager")
+	}
+
+	// configs returned by the resolver are checked for each bucket
+	bm.Resolver = func(id string) Config { return invalid }
+	if err := bm.ReconfigureAt(now, Config{Limit: 1, Burst: 10, Refill: time.Minute}, KeepTokens); !errors.Is(err, ErrInvalidLimit) {
+		t.Error("expected ErrInvalidLimit for the resolved config, got", err)
+	}
+}
+
+func TestBucketManager_ReconfigureConcurrent(t *testing.T) {
+	bm := New(5, 20, time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if i%2 == 0 {
+					bm.Reconfigure(Config{Limit: int64(j%5 + 1), Burst: 20, Refill: time.Millisecond}, KeepTokens)
+				} else {
+					bm.Allow(id)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,10 @@
+module github.com/zytekaron/gorl/redisstore
+
+go 1.18
+
+require (
+	github.com/yuin/gopher-lua v1.1.1
+	github.com/zytekaron/gorl v0.0.0
+)
+
+replace github.com/zytekaron/gorl => ../
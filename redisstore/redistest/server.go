@@ -0,0 +1,438 @@
+// Package redistest provides a small in-process stand-in for a Redis server,
+// so that redisstore can be tested without running Redis.
+//
+// The server implements only the commands used by redisstore. Scripts are
+// run by an embedded Lua interpreter, so the scripts sent by redisstore are
+// tested exactly as Redis would run them.
+package redistest
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+
+	"github.com/zytekaron/gorl"
+	"github.com/zytekaron/gorl/redisstore"
+)
+
+// Server is an in-process RESP server which holds its data in memory.
+type Server struct {
+	ln net.Listener
+	wg sync.WaitGroup
+
+	mux     sync.Mutex
+	clock   gorl.Clock
+	hashes  map[string]map[string]string
+	expires map[string]time.Time
+	scripts map[string]*lua.FunctionProto
+	conns   map[net.Conn]struct{}
+	closed  bool
+}
+
+// NewServer starts a new Server listening on a random local port.
+func NewServer() (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		ln:      ln,
+		clock:   gorl.RealClock,
+		hashes:  make(map[string]map[string]string),
+		expires: make(map[string]time.Time),
+		scripts: make(map[string]*lua.FunctionProto),
+		conns:   make(map[net.Conn]struct{}),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr returns the address that the server is listening on.
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Close stops the server and closes every open connection.
+func (s *Server) Close() error {
+	s.mux.Lock()
+	s.closed = true
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mux.Unlock()
+
+	err := s.ln.Close()
+	s.wg.Wait()
+	return err
+}
+
+// FlushScripts removes every loaded script, like SCRIPT FLUSH.
+func (s *Server) FlushScripts() {
+	s.mux.Lock()
+	s.scripts = make(map[string]*lua.FunctionProto)
+	s.mux.Unlock()
+}
+
+// CloseConns closes every open client connection, like CLIENT KILL,
+// while the server keeps accepting new ones.
+func (s *Server) CloseConns() {
+	s.mux.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mux.Unlock()
+}
+
+// SetClock sets the clock used to expire keys, which is the system clock by
+// default. A fake clock lets tests expire keys at the same times that they
+// pass to the store.
+func (s *Server) SetClock(clock gorl.Clock) {
+	s.mux.Lock()
+	s.clock = clock
+	s.mux.Unlock()
+}
+
+// Keys returns the number of keys held by the server.
+func (s *Server) Keys() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.expireLocked()
+	return len(s.hashes)
+}
+
+// TTL returns the time until the key expires, and whether it has an expiry.
+func (s *Server) TTL(key string) (time.Duration, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.expireLocked()
+
+	at, ok := s.expires[key]
+	return at.Sub(s.clock.Now()), ok
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mux.Lock()
+		if s.closed {
+			s.mux.Unlock()
+			c.Close()
+			return
+		}
+		s.conns[c] = struct{}{}
+		s.mux.Unlock()
+
+		s.wg.Add(1)
+		go s.handle(c)
+	}
+}
+
+func (s *Server) handle(c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mux.Lock()
+		delete(s.conns, c)
+		s.mux.Unlock()
+		c.Close()
+	}()
+
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+	for {
+		req, err := redisstore.ReadReply(r)
+		if err != nil {
+			return
+		}
+
+		var reply any
+		if args, ok := commandArgs(req); ok && len(args) > 0 {
+			s.mux.Lock()
+			reply = s.exec(args)
+			s.mux.Unlock()
+		} else {
+			reply = redisstore.Error("ERR invalid command")
+		}
+
+		if err := redisstore.WriteReply(w, reply); err != nil {
+			return
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// exec runs a command. The server must be locked for the duration of the call.
+func (s *Server) exec(args []string) any {
+	cmd, args := strings.ToUpper(args[0]), args[1:]
+	s.expireLocked()
+	switch {
+	case cmd == "PING":
+		return "PONG"
+	case cmd == "FLUSHALL":
+		s.hashes = make(map[string]map[string]string)
+		s.expires = make(map[string]time.Time)
+		return "OK"
+	case cmd == "DEL":
+		var n int64
+		for _, key := range args {
+			if _, ok := s.hashes[key]; ok {
+				s.deleteLocked(key)
+				n++
+			}
+		}
+		return n
+	case cmd == "PEXPIRE" && len(args) == 2:
+		ms, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return redisstore.Error("ERR value is not an integer or out of range")
+		}
+		if _, ok := s.hashes[args[0]]; !ok {
+			return int64(0)
+		}
+		if ms <= 0 {
+			s.deleteLocked(args[0])
+			return int64(1)
+		}
+		s.expires[args[0]] = s.clock.Now().Add(time.Duration(ms) * time.Millisecond)
+		return int64(1)
+	case cmd == "HSET" && len(args) >= 3 && len(args)%2 == 1:
+		hash, ok := s.hashes[args[0]]
+		if !ok {
+			hash = make(map[string]string)
+			s.hashes[args[0]] = hash
+		}
+		var added int64
+		for i := 1; i < len(args); i += 2 {
+			if _, ok := hash[args[i]]; !ok {
+				added++
+			}
+			hash[args[i]] = args[i+1]
+		}
+		return added
+	case cmd == "HMGET" && len(args) >= 2:
+		hash := s.hashes[args[0]]
+		values := make([]any, len(args)-1)
+		for i, field := range args[1:] {
+			if v, ok := hash[field]; ok {
+				values[i] = []byte(v)
+			}
+		}
+		return values
+	case cmd == "SCAN" && len(args) >= 1:
+		return s.scan(args[1:])
+	case cmd == "SCRIPT" && len(args) == 2 && strings.ToUpper(args[0]) == "LOAD":
+		proto, err := compile(args[1])
+		if err != nil {
+			return redisstore.Error("ERR Error compiling script " + err.Error())
+		}
+		sum := sha1.Sum([]byte(args[1]))
+		sha := hex.EncodeToString(sum[:])
+		s.scripts[sha] = proto
+		return []byte(sha)
+	case cmd == "EVALSHA" && len(args) >= 2:
+		proto, ok := s.scripts[args[0]]
+		if !ok {
+			return redisstore.Error("NOSCRIPT No matching script. Please use EVAL.")
+		}
+		return s.evalArgs(proto, args[1:])
+	case cmd == "EVAL" && len(args) >= 2:
+		proto, err := compile(args[0])
+		if err != nil {
+			return redisstore.Error("ERR Error compiling script " + err.Error())
+		}
+		return s.evalArgs(proto, args[1:])
+	}
+	return redisstore.Error(fmt.Sprintf("ERR unknown command or wrong number of arguments for '%s'", cmd))
+}
+
+// scan returns every matching key in a single page.
+func (s *Server) scan(args []string) any {
+	pattern := "*"
+	for i := 0; i+1 < len(args); i += 2 {
+		if strings.ToUpper(args[i]) == "MATCH" {
+			pattern = args[i+1]
+		}
+	}
+
+	keys := []any{}
+	for key := range s.hashes {
+		if ok, _ := path.Match(pattern, key); ok {
+			keys = append(keys, []byte(key))
+		}
+	}
+	return []any{[]byte("0"), keys}
+}
+
+// evalArgs splits the number of keys, the keys and the arguments
+// of EVAL or EVALSHA, and runs the script with them.
+func (s *Server) evalArgs(proto *lua.FunctionProto, args []string) any {
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 || n > len(args)-1 {
+		return redisstore.Error("ERR Number of keys can't be greater than number of args")
+	}
+	return s.eval(proto, args[1:1+n], args[1+n:])
+}
+
+// eval runs a compiled script with KEYS and ARGV set, and with redis.call
+// running commands on the server, which must be locked for the duration of
+// the call. Replies are converted to and from Lua as Redis converts them.
+func (s *Server) eval(proto *lua.FunctionProto, keys, argv []string) any {
+	L := lua.NewState()
+	defer L.Close()
+
+	strs := func(values []string) *lua.LTable {
+		t := L.NewTable()
+		for _, v := range values {
+			t.Append(lua.LString(v))
+		}
+		return t
+	}
+	L.SetGlobal("KEYS", strs(keys))
+	L.SetGlobal("ARGV", strs(argv))
+
+	redis := L.NewTable()
+	L.SetField(redis, "call", L.NewFunction(func(L *lua.LState) int {
+		args := make([]string, L.GetTop())
+		for i := range args {
+			args[i] = L.ToString(i + 1)
+		}
+		reply := s.exec(args)
+		if e, ok := reply.(redisstore.Error); ok {
+			L.RaiseError("%s", string(e))
+		}
+		L.Push(toLua(L, reply))
+		return 1
+	}))
+	L.SetField(redis, "error_reply", L.NewFunction(func(L *lua.LState) int {
+		t := L.NewTable()
+		L.SetField(t, "err", lua.LString(L.CheckString(1)))
+		L.Push(t)
+		return 1
+	}))
+	L.SetGlobal("redis", redis)
+
+	L.Push(L.NewFunctionFromProto(proto))
+	if err := L.PCall(0, 1, nil); err != nil {
+		return redisstore.Error("ERR Error running script: " + err.Error())
+	}
+	return fromLua(L.Get(-1))
+}
+
+// compile parses and compiles a script.
+func compile(src string) (*lua.FunctionProto, error) {
+	chunk, err := parse.Parse(strings.NewReader(src), "script")
+	if err != nil {
+		return nil, err
+	}
+	return lua.Compile(chunk, "script")
+}
+
+// toLua converts a reply to a Lua value, as Redis does for redis.call.
+func toLua(L *lua.LState, reply any) lua.LValue {
+	switch r := reply.(type) {
+	case int64:
+		return lua.LNumber(r)
+	case []byte:
+		return lua.LString(r)
+	case string:
+		t := L.NewTable()
+		L.SetField(t, "ok", lua.LString(r))
+		return t
+	case []any:
+		t := L.NewTable()
+		for _, elem := range r {
+			t.Append(toLua(L, elem))
+		}
+		return t
+	}
+	return lua.LFalse
+}
+
+// fromLua converts the value returned by a script to a reply, as Redis does.
+// Numbers are truncated to integers, and arrays end at the first nil.
+func fromLua(v lua.LValue) any {
+	switch v := v.(type) {
+	case lua.LNumber:
+		return int64(v)
+	case lua.LString:
+		return []byte(v)
+	case lua.LBool:
+		if v {
+			return int64(1)
+		}
+		return nil
+	case *lua.LTable:
+		if e, ok := v.RawGetString("err").(lua.LString); ok {
+			return redisstore.Error(e)
+		}
+		if ok, isStatus := v.RawGetString("ok").(lua.LString); isStatus {
+			return string(ok)
+		}
+		values := []any{}
+		for i := 1; ; i++ {
+			elem := v.RawGetInt(i)
+			if elem == lua.LNil {
+				break
+			}
+			values = append(values, fromLua(elem))
+		}
+		return values
+	}
+	return nil
+}
+
+// expireLocked removes every key whose expiry has passed.
+//
+// The server must be locked for the duration of the call.
+func (s *Server) expireLocked() {
+	now := s.clock.Now()
+	for key, at := range s.expires {
+		if !now.Before(at) {
+			s.deleteLocked(key)
+		}
+	}
+}
+
+// deleteLocked removes a key and its expiry.
+//
+// The server must be locked for the duration of the call.
+func (s *Server) deleteLocked(key string) {
+	delete(s.hashes, key)
+	delete(s.expires, key)
+}
+
+// commandArgs converts a request to its arguments.
+func commandArgs(req any) ([]string, bool) {
+	elems, ok := req.([]any)
+	if !ok {
+		return nil, false
+	}
+
+	args := make([]string, len(elems))
+	for i, elem := range elems {
+		b, ok := elem.([]byte)
+		if !ok {
+			return nil, false
+		}
+		args[i] = string(b)
+	}
+	return args, true
+}
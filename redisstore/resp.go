@@ -0,0 +1,204 @@
+package redisstore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Error is an error reply sent by the server.
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// errBroken is returned by a conn which has failed and can not be redialed.
+var errBroken = errors.New("redisstore: connection is broken")
+
+// conn is a minimal RESP client which sends one command at a time.
+//
+// If a command fails with a network error, the connection is closed, and
+// a new one is dialed before the next command if dial is set. The failed
+// command is not retried, since it may have been run by the server.
+type conn struct {
+	mux    sync.Mutex
+	dial   func() (net.Conn, error)
+	c      net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	closed bool
+}
+
+func newConn(c net.Conn, dial func() (net.Conn, error)) *conn {
+	cn := &conn{dial: dial}
+	cn.set(c)
+	return cn
+}
+
+func (c *conn) set(nc net.Conn) {
+	c.c = nc
+	c.r = bufio.NewReader(nc)
+	c.w = bufio.NewWriter(nc)
+}
+
+// do sends a command and returns its reply, which is one of string (simple
+// strings), []byte or nil (bulk strings), int64, []any or Error.
+func (c *conn) do(args ...string) (any, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.closed {
+		return nil, net.ErrClosed
+	}
+	if c.c == nil {
+		if c.dial == nil {
+			return nil, errBroken
+		}
+		nc, err := c.dial()
+		if err != nil {
+			return nil, err
+		}
+		c.set(nc)
+	}
+
+	reply, err := c.roundTrip(args)
+	if err != nil {
+		c.c.Close()
+		c.c = nil
+		return nil, err
+	}
+	if e, ok := reply.(Error); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+// roundTrip writes a command and reads its reply.
+func (c *conn) roundTrip(args []string) (any, error) {
+	if err := WriteCommand(c.w, args...); err != nil {
+		return nil, err
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return ReadReply(c.r)
+}
+
+func (c *conn) close() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.closed = true
+	if c.c == nil {
+		return nil
+	}
+	return c.c.Close()
+}
+
+// WriteCommand writes a command as a RESP array of bulk strings.
+func WriteCommand(w *bufio.Writer, args ...string) error {
+	if _, err := fmt.Fprintf(w, "*%d\r\n", len(args)); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if _, err := fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteReply writes a reply, which must be one of the types returned by ReadReply.
+func WriteReply(w *bufio.Writer, reply any) error {
+	var err error
+	switch v := reply.(type) {
+	case nil:
+		_, err = w.WriteString("$-1\r\n")
+	case string:
+		_, err = fmt.Fprintf(w, "+%s\r\n", v)
+	case Error:
+		_, err = fmt.Fprintf(w, "-%s\r\n", v)
+	case int64:
+		_, err = fmt.Fprintf(w, ":%d\r\n", v)
+	case []byte:
+		_, err = fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []any:
+		if _, err = fmt.Fprintf(w, "*%d\r\n", len(v)); err != nil {
+			return err
+		}
+		for _, elem := range v {
+			if err = WriteReply(w, elem); err != nil {
+				return err
+			}
+		}
+	default:
+		err = fmt.Errorf("redisstore: cannot write reply of type %T", reply)
+	}
+	return err
+}
+
+// ReadReply reads a single reply, which is one of string (simple strings),
+// []byte or nil (bulk strings), int64, []any (arrays) or Error.
+func ReadReply(r *bufio.Reader) (any, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redisstore: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:size], nil
+	case '*':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		elems := make([]any, size)
+		for i := range elems {
+			if elems[i], err = ReadReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return elems, nil
+	}
+	return nil, fmt.Errorf("redisstore: unexpected reply type %q", line[0])
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errors.New("redisstore: malformed line")
+	}
+	return line[:len(line)-2], nil
+}
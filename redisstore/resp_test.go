@@ -0,0 +1,48 @@
+package redisstore
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestReplyRoundTrip(t *testing.T) {
+	replies := []any{
+		"OK",
+		Error("ERR something went wrong"),
+		int64(-42),
+		[]byte("hello\r\nworld"),
+		nil,
+		[]any{int64(1), []byte("a"), nil, []any{"nested"}},
+	}
+
+	for _, want := range replies {
+		var buf bytes.Buffer
+		w := bufio.NewWriter(&buf)
+		if err := WriteReply(w, want); err != nil {
+			t.Fatal("unexpected error writing reply:", err)
+		}
+		w.Flush()
+
+		got, err := ReadReply(bufio.NewReader(&buf))
+		if err != nil {
+			t.Fatal("unexpected error reading reply:", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("expected reply %#v, got %#v", want, got)
+		}
+	}
+}
+
+func TestWriteCommand(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	WriteCommand(w, "HMGET", "key", "field")
+	w.Flush()
+
+	want := "*3\r\n$5\r\nHMGET\r\n$3\r\nkey\r\n$5\r\nfield\r\n"
+	if buf.String() != want {
+		t.Errorf("expected command %q, got %q", want, buf.String())
+	}
+}
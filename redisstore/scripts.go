@@ -0,0 +1,168 @@
+package redisstore
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+)
+
+// Buckets are stored as hashes with the fields limit, burst, refill, tokens
+// and last. Times and durations are stored in microseconds, because Lua
+// numbers are doubles and cannot represent nanosecond timestamps exactly.
+
+// common is shared by every script. It loads the bucket from KEYS[1], and
+// defines refill, which mirrors Bucket.refill and Bucket.skipDiff.
+const common = `
+local key = KEYS[1]
+local fields = redis.call('HMGET', key, 'limit', 'burst', 'refill', 'tokens', 'last')
+local exists = fields[1] ~= false
+local limit, burst, interval, tokens, last
+if exists then
+	limit = tonumber(fields[1])
+	burst = tonumber(fields[2])
+	interval = tonumber(fields[3])
+	tokens = tonumber(fields[4])
+	last = tonumber(fields[5])
+	if interval <= 0 then
+		return redis.error_reply('ERR gorl: refill interval must be positive')
+	end
+end
+
+local function refill(t)
+	if tokens == burst then
+		last = t
+		return
+	end
+
+	local delta = math.floor(math.abs(t - last) / interval)
+	last = last + delta * interval
+
+	tokens = tokens + delta * limit
+	if tokens >= burst then
+		tokens = burst
+		last = t
+	end
+end
+
+-- numbers are formatted explicitly, since the default conversion
+-- only keeps 14 significant digits, which would truncate timestamps.
+local function str(n)
+	return string.format('%d', n)
+end
+
+-- save stores the bucket and sets it to expire one interval after it would
+-- be fully refilled, since a full bucket is the same as a missing one.
+local function save(t)
+	redis.call('HSET', key, 'limit', str(limit), 'burst', str(burst), 'refill', str(interval),
+		'tokens', str(tokens), 'last', str(last))
+
+	local ttl = interval
+	if tokens < burst then
+		ttl = last + math.ceil((burst - tokens) / limit) * interval - t + interval
+		if ttl < interval then
+			ttl = interval
+		end
+	end
+	redis.call('PEXPIRE', key, str(math.ceil(ttl / 1000)))
+end
+`
+
+// applyScript implements Store.Apply.
+//
+// ARGV: limit, burst, refill, t, op, n
+// returns: ok, drawn, tokens, last, limit, burst, refill
+const applyScript = common + `
+local t = tonumber(ARGV[4])
+local op = tonumber(ARGV[5])
+local n = tonumber(ARGV[6])
+
+if not exists then
+	limit = tonumber(ARGV[1])
+	burst = tonumber(ARGV[2])
+	interval = tonumber(ARGV[3])
+	tokens = burst
+	last = 0
+	if interval <= 0 then
+		return redis.error_reply('ERR gorl: refill interval must be positive')
+	end
+end
+
+local ok, drawn = 1, 0
+if op == 5 then -- OpReset
+	tokens = burst
+	last = t
+else
+	refill(t)
+	if op == 0 then -- OpCheck
+		if tokens < n then ok = 0 end
+	elseif op == 1 then -- OpDraw
+		if tokens < n then
+			ok = 0
+		else
+			tokens = tokens - n
+			drawn = n
+		end
+	elseif op == 2 then -- OpDrawMax
+		drawn = math.min(n, tokens)
+		tokens = tokens - drawn
+	elseif op == 3 then -- OpForceDraw
+		tokens = tokens - n
+		drawn = n
+	elseif op == 4 then -- OpSet
+		tokens = n
+	elseif op == 6 then -- OpReserve
+		if n > burst then
+			ok = 0
+		else
+			tokens = tokens - n
+			drawn = n
+		end
+	elseif op == 7 then -- OpRefund
+		tokens = tokens + n
+		if tokens >= burst then
+			tokens = burst
+			last = t
+		end
+	end
+end
+
+save(t)
+return {ok, drawn, tokens, last, limit, burst, interval}
+`
+
+// purgeScript deletes the bucket if it is fully refilled.
+//
+// ARGV: t
+// returns: 1 if the bucket was deleted, or 0 otherwise
+const purgeScript = common + `
+if not exists then
+	return 0
+end
+
+local t = tonumber(ARGV[1])
+refill(t)
+if tokens == burst then
+	redis.call('DEL', key)
+	return 1
+end
+save(t)
+return 0
+`
+
+// script is a Lua script which is run using EVALSHA.
+type script struct {
+	src string
+	sha string
+}
+
+func newScript(src string) *script {
+	sum := sha1.Sum([]byte(src))
+	return &script{
+		src: src,
+		sha: hex.EncodeToString(sum[:]),
+	}
+}
+
+var (
+	apply = newScript(applyScript)
+	purge = newScript(purgeScript)
+)
@@ -0,0 +1,337 @@
+// Package redisstore provides a gorl.Store which keeps buckets in Redis, or any
+// server which speaks the Redis protocol, so that several processes can share
+// the same limits.
+//
+// The store talks RESP directly over a net.Conn. Each operation refills and
+// updates a bucket atomically on the server using a Lua script run by EVALSHA,
+// so the scripts are loaded on demand if the server does not have them cached.
+//
+// redisstore is a separate module from gorl, so that the Lua interpreter used
+// by redistest is not a dependency of programs which only use gorl.
+package redisstore
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zytekaron/gorl"
+)
+
+// DefaultPrefix is the prefix added to bucket ids to form their keys.
+const DefaultPrefix = "gorl:"
+
+// Store is a gorl.Store which keeps buckets in a Redis server.
+//
+// Times are stored with microsecond precision, so refill intervals must be at
+// least a microsecond. Commands are sent one at a time over a single
+// connection, so a Store is safe for concurrent use.
+//
+// Each bucket expires once it would be fully refilled, so buckets which are
+// no longer used are removed by the server without calling Purge.
+type Store struct {
+	// Prefix is added to each bucket id to form its key.
+	Prefix string
+
+	conn *conn
+}
+
+// New creates a new Store which sends commands over the provided connection.
+//
+// If the connection fails, every later command returns an error. Use Dial
+// to create a Store which reconnects.
+func New(c net.Conn) *Store {
+	return &Store{
+		Prefix: DefaultPrefix,
+		conn:   newConn(c, nil),
+	}
+}
+
+// Dial connects to the server at the address and creates a new Store.
+//
+// If the connection fails, the command which was being sent returns an
+// error, and the Store connects again before sending the next command.
+func Dial(network, address string) (*Store, error) {
+	dial := func() (net.Conn, error) {
+		return net.Dial(network, address)
+	}
+
+	c, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
+		Prefix: DefaultPrefix,
+		conn:   newConn(c, dial),
+	}, nil
+}
+
+// Close closes the connection to the server.
+func (s *Store) Close() error {
+	return s.conn.close()
+}
+
+// Apply refills the bucket at the specified time and then performs op with n.
+func (s *Store) Apply(id string, cfg gorl.Config, t time.Time, op gorl.Op, n int64) (gorl.Result, error) {
+	if err := validate(cfg); err != nil {
+		return gorl.Result{}, err
+	}
+
+	reply, err := s.eval(apply, s.Prefix+id,
+		itoa(cfg.Limit),
+		itoa(cfg.Burst),
+		itoa(cfg.Refill.Microseconds()),
+		itoa(toMicro(t)),
+		itoa(int64(op)),
+		itoa(n),
+	)
+	if err != nil {
+		return gorl.Result{}, err
+	}
+
+	values, err := int64s(reply, 7)
+	if err != nil {
+		return gorl.Result{}, err
+	}
+	return gorl.Result{
+		OK:    values[0] == 1,
+		Drawn: values[1],
+		State: gorl.State{
+			Config: gorl.Config{
+				Limit:  values[4],
+				Burst:  values[5],
+				Refill: time.Duration(values[6]) * time.Microsecond,
+			},
+			Tokens:     values[2],
+			LastUpdate: fromMicro(values[3]),
+		},
+	}, nil
+}
+
+// Load returns the state of the bucket without refilling or creating it.
+func (s *Store) Load(id string) (gorl.State, bool, error) {
+	reply, err := s.conn.do("HMGET", s.Prefix+id, "limit", "burst", "refill", "tokens", "last")
+	if err != nil {
+		return gorl.State{}, false, err
+	}
+
+	fields, ok := reply.([]any)
+	if !ok || len(fields) != 5 {
+		return gorl.State{}, false, fmt.Errorf("redisstore: unexpected reply %v", reply)
+	}
+	if fields[0] == nil {
+		return gorl.State{}, false, nil
+	}
+
+	values := make([]int64, len(fields))
+	for i, field := range fields {
+		b, ok := field.([]byte)
+		if !ok {
+			return gorl.State{}, false, fmt.Errorf("redisstore: unexpected field %v", field)
+		}
+		if values[i], err = strconv.ParseInt(string(b), 10, 64); err != nil {
+			return gorl.State{}, false, err
+		}
+	}
+	return gorl.State{
+		Config: gorl.Config{
+			Limit:  values[0],
+			Burst:  values[1],
+			Refill: time.Duration(values[2]) * time.Microsecond,
+		},
+		Tokens:     values[3],
+		LastUpdate: fromMicro(values[4]),
+	}, true, nil
+}
+
+// Save replaces the state of the bucket.
+//
+// The bucket expires one interval after the time that it would be fully
+// refilled if it had been updated when it was saved.
+func (s *Store) Save(id string, st gorl.State) error {
+	if err := validate(st.Config); err != nil {
+		return err
+	}
+
+	key := s.Prefix + id
+	_, err := s.conn.do("HSET", key,
+		"limit", itoa(st.Limit),
+		"burst", itoa(st.Burst),
+		"refill", itoa(st.Refill.Microseconds()),
+		"tokens", itoa(st.Tokens),
+		"last", itoa(toMicro(st.LastUpdate)),
+	)
+	if err != nil {
+		return err
+	}
+
+	ttl := st.Refill
+	if missing := st.Burst - st.Tokens; missing > 0 {
+		ttl += time.Duration((missing+st.Limit-1)/st.Limit) * st.Refill
+	}
+	_, err = s.conn.do("PEXPIRE", key, itoa(int64((ttl+time.Millisecond-1)/time.Millisecond)))
+	return err
+}
+
+// Delete removes the bucket.
+func (s *Store) Delete(id string) error {
+	_, err := s.conn.do("DEL", s.Prefix+id)
+	return err
+}
+
+// Purge removes every bucket which is fully refilled at the specified time.
+//
+// The keys are found using SCAN, and each one is checked and removed
+// atomically, so buckets which are drawn from during the purge are kept.
+func (s *Store) Purge(t time.Time) (int, error) {
+	removed := 0
+	at := itoa(toMicro(t))
+
+	err := s.scan(func(key string) (bool, error) {
+		reply, err := s.eval(purge, key, at)
+		if err != nil {
+			return false, err
+		}
+		if reply == int64(1) {
+			removed++
+		}
+		return true, nil
+	})
+	return removed, err
+}
+
+// Range calls fn with the state of each bucket until fn returns false.
+//
+// The keys are found using SCAN, so buckets which are added or removed
+// during the iteration may or may not be visited.
+func (s *Store) Range(fn func(id string, st gorl.State) bool) error {
+	return s.scan(func(key string) (bool, error) {
+		id := strings.TrimPrefix(key, s.Prefix)
+		st, ok, err := s.Load(id)
+		if err != nil || !ok {
+			return err == nil, err
+		}
+		return fn(id, st), nil
+	})
+}
+
+// scan calls fn with each key which has the store's prefix,
+// until fn returns false or an error.
+func (s *Store) scan(fn func(key string) (bool, error)) error {
+	match := escapeGlob(s.Prefix) + "*"
+
+	cursor := "0"
+	for {
+		reply, err := s.conn.do("SCAN", cursor, "MATCH", match, "COUNT", "100")
+		if err != nil {
+			return err
+		}
+
+		page, ok := reply.([]any)
+		if !ok || len(page) != 2 {
+			return fmt.Errorf("redisstore: unexpected reply %v", reply)
+		}
+		next, ok := page[0].([]byte)
+		keys, ok2 := page[1].([]any)
+		if !ok || !ok2 {
+			return fmt.Errorf("redisstore: unexpected reply %v", reply)
+		}
+
+		for _, key := range keys {
+			k, ok := key.([]byte)
+			if !ok {
+				return fmt.Errorf("redisstore: unexpected key %v", key)
+			}
+			if more, err := fn(string(k)); !more || err != nil {
+				return err
+			}
+		}
+
+		cursor = string(next)
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+// eval runs the script on a single key with EVALSHA,
+// loading the script first if the server does not have it.
+func (s *Store) eval(sc *script, key string, args ...string) (any, error) {
+	cmd := append([]string{"EVALSHA", sc.sha, "1", key}, args...)
+
+	reply, err := s.conn.do(cmd...)
+	var e Error
+	if errors.As(err, &e) && strings.HasPrefix(string(e), "NOSCRIPT") {
+		if _, err := s.conn.do("SCRIPT", "LOAD", sc.src); err != nil {
+			return nil, err
+		}
+		reply, err = s.conn.do(cmd...)
+	}
+	return reply, err
+}
+
+// validate returns an error if the config is invalid, or if its refill
+// interval is too short to be stored in microseconds.
+func validate(cfg gorl.Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if cfg.Refill < time.Microsecond {
+		return fmt.Errorf("%w, got %s (must be at least 1µs)", gorl.ErrInvalidRefill, cfg.Refill)
+	}
+	return nil
+}
+
+// int64s converts an array reply of integers.
+func int64s(reply any, n int) ([]int64, error) {
+	elems, ok := reply.([]any)
+	if !ok || len(elems) != n {
+		return nil, fmt.Errorf("redisstore: unexpected reply %v", reply)
+	}
+
+	values := make([]int64, n)
+	for i, elem := range elems {
+		v, ok := elem.(int64)
+		if !ok {
+			return nil, fmt.Errorf("redisstore: unexpected reply %v", reply)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// toMicro converts a time to unix microseconds, with the zero time as 0.
+func toMicro(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMicro()
+}
+
+// fromMicro converts unix microseconds to a time, with 0 as the zero time.
+func fromMicro(us int64) time.Time {
+	if us == 0 {
+		return time.Time{}
+	}
+	return time.UnixMicro(us)
+}
+
+// escapeGlob escapes the characters which have a special meaning in a SCAN pattern.
+func escapeGlob(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+func itoa(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
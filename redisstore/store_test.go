@@ -0,0 +1,313 @@
+package redisstore_test
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zytekaron/gorl"
+	"github.com/zytekaron/gorl/gorltest"
+	"github.com/zytekaron/gorl/redisstore"
+	"github.com/zytekaron/gorl/redisstore/redistest"
+	"github.com/zytekaron/gorl/storetest"
+)
+
+func newServer(t *testing.T) *redistest.Server {
+	srv, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal("failed to start server:", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+func dial(t *testing.T, srv *redistest.Server) *redisstore.Store {
+	s, err := redisstore.Dial("tcp", srv.Addr())
+	if err != nil {
+		t.Fatal("failed to connect to server:", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// redisAddr returns the address of the real server named by GORL_REDIS_ADDR,
+// or skips the test if it is not set.
+func redisAddr(t *testing.T) string {
+	addr := os.Getenv("GORL_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("GORL_REDIS_ADDR is not set")
+	}
+	return addr
+}
+
+// dialRedis connects to a real server. Each store has a unique prefix,
+// and its buckets are removed when the test finishes.
+func dialRedis(t *testing.T, addr string) *redisstore.Store {
+	s, err := redisstore.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("failed to connect to server:", err)
+	}
+	s.Prefix = fmt.Sprintf("gorl-test:%d:", rand.Int63())
+	t.Cleanup(func() {
+		var ids []string
+		s.Range(func(id string, _ gorl.State) bool {
+			ids = append(ids, id)
+			return true
+		})
+		for _, id := range ids {
+			s.Delete(id)
+		}
+		s.Close()
+	})
+	return s
+}
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func() gorl.Store {
+		return dial(t, newServer(t))
+	})
+}
+
+func TestStore_Redis(t *testing.T) {
+	addr := redisAddr(t)
+	storetest.Run(t, func() gorl.Store {
+		return dialRedis(t, addr)
+	})
+}
+
+func TestStore_MatchesMemoryStore(t *testing.T) {
+	srv := newServer(t)
+	clock := gorltest.NewFakeClock(time.UnixMicro(1_700_000_000_000_000))
+	srv.SetClock(clock)
+
+	testMatchesMemoryStore(t, dial(t, srv), clock.Now(), func(now time.Time) time.Time {
+		clock.Set(now)
+		return now
+	})
+}
+
+func TestStore_MatchesMemoryStore_Redis(t *testing.T) {
+	s := dialRedis(t, redisAddr(t))
+
+	// the server expires keys using its own clock, so the times passed
+	// to the store must not fall behind it.
+	start := time.Now().Truncate(time.Microsecond)
+	testMatchesMemoryStore(t, s, start, func(now time.Time) time.Time {
+		if floor := start.Add(time.Since(start)).Truncate(time.Microsecond); now.Before(floor) {
+			return floor
+		}
+		return now
+	})
+}
+
+// testMatchesMemoryStore runs the same random operations against s and a
+// gorl.MemoryStore, whose buckets use Bucket.apply, and expects the scripts
+// to give the same results. sync is called with the time of each operation,
+// and returns the time to use instead.
+func testMatchesMemoryStore(t *testing.T, s gorl.Store, now time.Time, sync func(time.Time) time.Time) {
+	configs := []gorl.Config{
+		{Limit: 1, Burst: 1, Refill: time.Second},
+		{Limit: 5, Burst: 20, Refill: time.Second},
+		{Limit: 3, Burst: 10, Refill: 7 * time.Millisecond},
+		{Limit: 100, Burst: 1000, Refill: time.Minute},
+		{Limit: 1, Burst: 50, Refill: time.Microsecond},
+	}
+	ops := []gorl.Op{
+		gorl.OpCheck, gorl.OpDraw, gorl.OpDrawMax, gorl.OpForceDraw,
+		gorl.OpSet, gorl.OpReset, gorl.OpReserve, gorl.OpRefund,
+	}
+
+	r := rand.New(rand.NewSource(1))
+	mem := gorl.NewMemoryStore()
+	for i, cfg := range configs {
+		id := fmt.Sprint("bucket-", i)
+
+		for step := 0; step < 500; step++ {
+			// mostly move forward by up to a few intervals, sometimes stay
+			// at the same time, and sometimes go back, which the scripts
+			// must handle the same way as Bucket.
+			switch jitter := time.Duration(r.Int63n(int64(3*cfg.Refill/time.Microsecond)+1)) * time.Microsecond; r.Intn(10) {
+			case 0:
+			case 1:
+				now = now.Add(-(jitter / 3).Truncate(time.Microsecond))
+			default:
+				now = now.Add(jitter)
+			}
+			now = sync(now)
+
+			op := ops[r.Intn(len(ops))]
+			n := r.Int63n(cfg.Burst + 3)
+
+			want, err := mem.Apply(id, cfg, now, op, n)
+			if err != nil {
+				t.Fatal("unexpected error from MemoryStore.Apply:", err)
+			}
+			got, err := s.Apply(id, cfg, now, op, n)
+			if err != nil {
+				t.Fatal("unexpected error from Apply:", err)
+			}
+			if got.OK != want.OK || got.Drawn != want.Drawn || got.Config != want.Config ||
+				got.Tokens != want.Tokens || !got.LastUpdate.Equal(want.LastUpdate) {
+				t.Fatalf("config %d step %d: op %d with %d: expected %+v, got %+v", i, step, op, n, want, got)
+			}
+		}
+
+		// buckets which expired are not counted by Purge,
+		// so compare the buckets that are left instead.
+		now = sync(now.Add(time.Duration(r.Int63n(int64(3*cfg.Refill/time.Microsecond))) * time.Microsecond))
+		mem.Purge(now)
+		if _, err := s.Purge(now); err != nil {
+			t.Fatal("unexpected error from Purge:", err)
+		}
+		if want, got := states(t, mem), states(t, s); !reflect.DeepEqual(got, want) {
+			t.Errorf("config %d: expected buckets after purge to be %v, got %v", i, want, got)
+		}
+	}
+}
+
+// states returns the state of each bucket in the store, with times in UTC.
+func states(t *testing.T, s gorl.Store) map[string]gorl.State {
+	states := make(map[string]gorl.State)
+	err := s.Range(func(id string, st gorl.State) bool {
+		st.LastUpdate = st.LastUpdate.UTC()
+		states[id] = st
+		return true
+	})
+	if err != nil {
+		t.Fatal("unexpected error from Range:", err)
+	}
+	return states
+}
+
+func TestStore_ReloadScripts(t *testing.T) {
+	srv := newServer(t)
+	s := dial(t, srv)
+	cfg := gorl.Config{Limit: 5, Burst: 20, Refill: time.Second}
+	now := time.Now()
+
+	if _, err := s.Apply("a", cfg, now, gorl.OpDraw, 5); err != nil {
+		t.Fatal("unexpected error from Apply:", err)
+	}
+
+	srv.FlushScripts()
+	res, err := s.Apply("a", cfg, now, gorl.OpDraw, 5)
+	if err != nil {
+		t.Fatal("expected scripts to be reloaded after a flush, got", err)
+	}
+	if res.Tokens != 10 {
+		t.Error("expected token count to be 10, got", res.Tokens)
+	}
+}
+
+func TestStore_Prefix(t *testing.T) {
+	srv := newServer(t)
+	a := dial(t, srv)
+	b := dial(t, srv)
+	b.Prefix = "other:"
+	cfg := gorl.Config{Limit: 5, Burst: 20, Refill: time.Second}
+	now := time.Now()
+
+	a.Apply("id", cfg, now, gorl.OpDraw, 20)
+	res, _ := b.Apply("id", cfg, now, gorl.OpCheck, 0)
+	if res.Tokens != 20 {
+		t.Error("expected stores with different prefixes to not share buckets, have", res.Tokens)
+	}
+
+	removed, err := b.Purge(now)
+	if err != nil {
+		t.Fatal("unexpected error from Purge:", err)
+	}
+	if removed != 1 || srv.Keys() != 1 {
+		t.Errorf("expected purge to only remove buckets with its prefix, removed %d", removed)
+	}
+}
+
+func TestBucketManager_Shared(t *testing.T) {
+	srv := newServer(t)
+	bm1 := gorl.NewWithStore(5, 20, time.Second, dial(t, srv))
+	bm2 := gorl.NewWithStore(5, 20, time.Second, dial(t, srv))
+	now := time.Now()
+
+	if !bm1.DrawAt("a", now, 15) {
+		t.Error("expected to be able to draw 15 tokens from the first manager")
+	}
+	if bm2.DrawAt("a", now, 6) {
+		t.Error("expected to NOT be able to draw 6 tokens from the second manager (have 5)")
+	}
+	if remain := bm2.RemainingAt("a", now); remain != 5 {
+		t.Error("expected remaining tokens to be 5, got", remain)
+	}
+}
+
+func TestStore_InvalidRefill(t *testing.T) {
+	s := dial(t, newServer(t))
+	now := time.Now()
+
+	for _, refill := range []time.Duration{0, -time.Second, time.Nanosecond, 999} {
+		cfg := gorl.Config{Limit: 1, Burst: 1, Refill: refill}
+		if _, err := s.Apply("a", cfg, now, gorl.OpDraw, 1); !errors.Is(err, gorl.ErrInvalidRefill) {
+			t.Errorf("expected Apply with refill %s to return ErrInvalidRefill, got %v", refill, err)
+		}
+		if err := s.Save("a", gorl.State{Config: cfg}); !errors.Is(err, gorl.ErrInvalidRefill) {
+			t.Errorf("expected Save with refill %s to return ErrInvalidRefill, got %v", refill, err)
+		}
+	}
+}
+
+func TestStore_Expire(t *testing.T) {
+	srv := newServer(t)
+	clock := gorltest.NewFakeClock(time.Now())
+	srv.SetClock(clock)
+	s := dial(t, srv)
+	cfg := gorl.Config{Limit: 5, Burst: 20, Refill: time.Second}
+	now := clock.Now()
+
+	// 12 tokens are missing, which takes 3 intervals to refill,
+	// and the bucket expires one interval after that.
+	if _, err := s.Apply("a", cfg, now, gorl.OpDraw, 12); err != nil {
+		t.Fatal("unexpected error from Apply:", err)
+	}
+	if ttl, ok := srv.TTL(redisstore.DefaultPrefix + "a"); !ok || ttl != 4*time.Second {
+		t.Error("expected bucket to expire in 4s, got", ttl, ok)
+	}
+
+	err := s.Save("b", gorl.State{Config: cfg, Tokens: 19, LastUpdate: now})
+	if err != nil {
+		t.Fatal("unexpected error from Save:", err)
+	}
+	if ttl, ok := srv.TTL(redisstore.DefaultPrefix + "b"); !ok || ttl != 2*time.Second {
+		t.Error("expected saved bucket to expire in 2s, got", ttl, ok)
+	}
+
+	clock.Advance(4 * time.Second)
+	if _, ok, _ := s.Load("a"); ok {
+		t.Error("expected bucket to have expired")
+	}
+}
+
+func TestStore_Reconnect(t *testing.T) {
+	srv := newServer(t)
+	s := dial(t, srv)
+	cfg := gorl.Config{Limit: 5, Burst: 20, Refill: time.Second}
+	now := time.Now()
+
+	if _, err := s.Apply("a", cfg, now, gorl.OpDraw, 5); err != nil {
+		t.Fatal("unexpected error from Apply:", err)
+	}
+
+	// the first command after the connection is closed may fail,
+	// but the next one must be sent over a new connection.
+	srv.CloseConns()
+	s.Apply("a", cfg, now, gorl.OpCheck, 0)
+	res, err := s.Apply("a", cfg, now, gorl.OpCheck, 0)
+	if err != nil {
+		t.Fatal("expected store to reconnect, got", err)
+	}
+	if res.Tokens != 15 {
+		t.Error("expected token count to be 15, got", res.Tokens)
+	}
+}
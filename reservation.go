@@ -0,0 +1,120 @@
+package gorl
+
+import (
+	"sync"
+	"time"
+)
+
+// Reservation holds tokens which have been drawn from a Bucket in advance,
+// along with the time at which the holder is allowed to act on them.
+//
+// A Reservation which is not OK holds no tokens, and will never be valid.
+type Reservation struct {
+	ok        bool
+	tokens    int64
+	timeToAct time.Time
+	clock     Clock
+
+	refund    func(t time.Time, n int64)
+	mux       sync.Mutex
+	cancelled bool
+}
+
+// Reserve reserves n tokens from the bucket, drawing them immediately
+// (and overdrafting if necessary), and returns a Reservation which
+// reports how long the caller must wait before acting on them.
+func (b *Bucket) Reserve(n int64) *Reservation {
+	return b.ReserveAt(b.now(), n)
+}
+
+// ReserveAt reserves n tokens from the bucket at the specified time, drawing
+// them immediately (and overdrafting if necessary), and returns a Reservation
+// which reports how long the caller must wait before acting on them.
+//
+// If n exceeds the burst quantity the reservation can never be satisfied,
+// so no tokens are drawn and the returned Reservation is not OK.
+func (b *Bucket) ReserveAt(t time.Time, n int64) *Reservation {
+	res := b.apply(t, OpReserve, n)
+	return newReservation(res, t, b.Clock, func(t time.Time, n int64) {
+		b.apply(t, OpRefund, n)
+	})
+}
+
+// newReservation creates a Reservation from the result of an OpReserve
+// operation applied at the specified time. refund is called with the
+// reserved tokens if the reservation is cancelled before its time to act.
+func newReservation(res Result, t time.Time, clock Clock, refund func(t time.Time, n int64)) *Reservation {
+	r := &Reservation{
+		ok:        res.OK,
+		tokens:    res.Drawn,
+		timeToAct: t,
+		clock:     clockOrReal(clock),
+		refund:    refund,
+	}
+	if res.OK {
+		r.timeToAct = t.Add(timeUntil(res.State, t, 0))
+	}
+	return r
+}
+
+// OK returns whether the reservation holds tokens which will become usable.
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Tokens returns the number of tokens held by the reservation.
+func (r *Reservation) Tokens() int64 {
+	return r.tokens
+}
+
+// TimeToAct returns the time at which the reserved tokens may be used.
+func (r *Reservation) TimeToAct() time.Time {
+	return r.timeToAct
+}
+
+// Delay returns how long the caller must wait from now before acting on the reservation.
+func (r *Reservation) Delay() time.Duration {
+	return r.DelayFrom(r.clock.Now())
+}
+
+// DelayFrom returns how long the caller must wait from the specified time before acting
+// on the reservation, which is zero if the time to act has already been reached.
+// It returns InfDuration if the reservation is not OK, since it will never be valid.
+func (r *Reservation) DelayFrom(t time.Time) time.Duration {
+	if !r.ok {
+		return InfDuration
+	}
+	delay := r.timeToAct.Sub(t)
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// Cancel cancels the reservation, returning its tokens to the bucket
+// if the time to act has not yet been reached.
+func (r *Reservation) Cancel() {
+	r.CancelAt(r.clock.Now())
+}
+
+// CancelAt cancels the reservation at the specified time, returning all of its
+// tokens to the bucket if the time to act has not yet been reached. Once the
+// time to act has been reached, the tokens are considered used, and none of
+// them are returned. Refunds are not prorated by how much of the delay has
+// elapsed.
+//
+// The bucket will not be refilled beyond its burst quantity.
+func (r *Reservation) CancelAt(t time.Time) {
+	if !r.ok {
+		return
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.cancelled || !t.Before(r.timeToAct) {
+		return
+	}
+	r.cancelled = true
+	r.refund(t, r.tokens)
+}
@@ -0,0 +1,118 @@
+package gorl
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucket_Reserve(t *testing.T) {
+	now := time.Now()
+	b := NewBucket(5, 10, time.Second)
+
+	// have=10, reserve 10 -> usable immediately
+	r := b.ReserveAt(now, 10)
+	if !r.OK() {
+		t.Fatal("expected reservation of 10 tokens to be ok")
+	}
+	if delay := r.DelayFrom(now); delay != 0 {
+		t.Error("expected no delay for the first reservation, got", delay)
+	}
+
+	// have=0, reserve 5 -> usable after 1 refill
+	r = b.ReserveAt(now, 5)
+	if delay := r.DelayFrom(now); delay != time.Second {
+		t.Errorf("expected delay of %s, got %s", time.Second, delay)
+	}
+
+	// have=-5, reserve 7 -> have=-12, usable after 3 refills
+	r = b.ReserveAt(now, 7)
+	if delay := r.DelayFrom(now); delay != 3*time.Second {
+		t.Errorf("expected delay of %s, got %s", 3*time.Second, delay)
+	}
+	if !r.TimeToAct().Equal(now.Add(3 * time.Second)) {
+		t.Error("expected time to act to be 3 refills from now")
+	}
+}
+
+func TestBucket_ReserveExceedsBurst(t *testing.T) {
+	now := time.Now()
+	b := NewBucket(5, 10, time.Second)
+
+	r := b.ReserveAt(now, 11)
+	if r.OK() {
+		t.Error("expected reservation exceeding burst to not be ok")
+	}
+	if delay := r.DelayFrom(now); delay != InfDuration {
+		t.Error("expected reservation exceeding burst to never be usable, got", delay)
+	}
+	if tokens := b.TokensAt(now); tokens != 10 {
+		t.Error("expected no tokens to be drawn, have", tokens)
+	}
+}
+
+func TestReservation_Cancel(t *testing.T) {
+	now := time.Now()
+	b := NewBucket(5, 10, time.Second)
+
+	b.DrawAt(now, 10)
+	r := b.ReserveAt(now, 5)
+	if tokens := b.TokensAt(now); tokens != -5 {
+		t.Error("expected token count to be -5, got", tokens)
+	}
+
+	r.CancelAt(now)
+	if tokens := b.TokensAt(now); tokens != 0 {
+		t.Error("expected token count to be 0 after cancel, got", tokens)
+	}
+
+	// cancelling twice must not refund twice
+	r.CancelAt(now)
+	if tokens := b.TokensAt(now); tokens != 0 {
+		t.Error("expected token count to be 0 after second cancel, got", tokens)
+	}
+}
+
+func TestReservation_CancelAfterTimeToAct(t *testing.T) {
+	now := time.Now()
+	b := NewBucket(5, 10, time.Second)
+
+	b.DrawAt(now, 10)
+	r := b.ReserveAt(now, 5)
+
+	later := now.Add(time.Second)
+	r.CancelAt(later)
+	if tokens := b.TokensAt(later); tokens != 0 {
+		t.Error("expected used reservation to not be refunded, have", tokens)
+	}
+}
+
+func TestReservation_CancelPartlyElapsed(t *testing.T) {
+	now := time.Now()
+	b := NewBucket(5, 10, time.Second)
+
+	b.DrawAt(now, 10)
+	r := b.ReserveAt(now, 10)
+
+	// half of the delay has elapsed, but refunds are not prorated,
+	// so every reserved token is returned.
+	later := now.Add(time.Second)
+	r.CancelAt(later)
+	if tokens := b.TokensAt(later); tokens != 5 {
+		t.Error("expected every reserved token to be refunded, have", tokens)
+	}
+}
+
+func TestBucketManager_Reserve(t *testing.T) {
+	now := time.Now()
+	bm := New(5, 10, time.Second)
+
+	bm.ReserveAt(id, now, 10)
+	r := bm.ReserveAt(id, now, 5)
+	if delay := r.DelayFrom(now); delay != time.Second {
+		t.Errorf("expected delay of %s, got %s", time.Second, delay)
+	}
+	r.CancelAt(now)
+	if tokens := bm.TokensAt(id, now); tokens != 0 {
+		t.Error("expected token count to be 0 after cancel, got", tokens)
+	}
+}
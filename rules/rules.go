@@ -0,0 +1,446 @@
+// Package rules builds rate limiters from a declarative JSON configuration,
+// so that limits can be changed without changing code.
+//
+// A configuration is a list of rules, each of which applies to requests
+// whose route and key match its patterns:
+//
+//	{
+//	  "rules": [
+//	    {
+//	      "name": "api",
+//	      "routes": ["/api/*"],
+//	      "limits": ["10/1s burst 20", "1000/1h"],
+//	      "tiers": [
+//	        {"name": "pro", "keys": ["pro:*"], "limits": ["100/1s burst 200", "10000/1h"]}
+//	      ]
+//	    },
+//	    {"name": "default", "limits": "5/1s"}
+//	  ]
+//	}
+//
+// Rates are written in the format accepted by gorl.ParseRate, such as
+// "100/1m burst 200", "5k/1h" or "10 per minute". The burst defaults to
+// the limit.
+//
+// A rule with several limits enforces all of them at once, as composite
+// windows. Keys which match the patterns of a tier are given its limits
+// instead, which must have the same number of windows as the rule.
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/zytekaron/gorl"
+)
+
+// File is a rule configuration, as it is written in JSON.
+type File struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule defines a named limiter and the requests it applies to.
+type Rule struct {
+	// Name identifies the limiter, and must be unique.
+	Name string `json:"name"`
+	// Routes are path.Match patterns for the routes the rule applies to.
+	// The rule applies to every route if there are none.
+	Routes []string `json:"routes,omitempty"`
+	// Keys are path.Match patterns for the keys the rule applies to.
+	// The rule applies to every key if there are none.
+	Keys []string `json:"keys,omitempty"`
+	// Limits are the rates which are enforced together.
+	Limits Rates `json:"limits"`
+	// Tiers give different limits to the keys which match their patterns.
+	Tiers []Tier `json:"tiers,omitempty"`
+}
+
+// Tier defines the limits for a group of keys within a rule.
+type Tier struct {
+	// Name identifies the tier, and must be unique within the rule.
+	Name string `json:"name"`
+	// Keys are path.Match patterns for the keys in the tier.
+	Keys []string `json:"keys"`
+	// Limits replace the limits of the rule, window for window.
+	Limits Rates `json:"limits"`
+}
+
+// Rates is a list of rates, which may be written in JSON as a single string.
+type Rates []string
+
+// UnmarshalJSON accepts either a string or an array of strings.
+func (r *Rates) UnmarshalJSON(data []byte) error {
+	var rate string
+	if err := json.Unmarshal(data, &rate); err == nil {
+		*r = Rates{rate}
+		return nil
+	}
+
+	var rates []string
+	if err := json.Unmarshal(data, &rates); err != nil {
+		return errors.New("rules: limits must be a string or an array of strings")
+	}
+	*r = rates
+	return nil
+}
+
+// RuleError is returned when a rule is invalid. It identifies the rule
+// and the field which is invalid, such as "limits[1]".
+type RuleError struct {
+	// Index is the position of the rule in the configuration.
+	Index int
+	// Name is the name of the rule, which may be empty.
+	Name string
+	// Field is the field of the rule which is invalid.
+	Field string
+	Err   error
+}
+
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("rules: rule %d (%q): %s: %v", e.Index, e.Name, e.Field, e.Err)
+}
+
+func (e *RuleError) Unwrap() error {
+	return e.Err
+}
+
+// Set is a set of limiters built from a configuration.
+type Set struct {
+	limiters []*Limiter
+	byName   map[string]*Limiter
+}
+
+// Load reads a JSON configuration and builds its limiters.
+func Load(r io.Reader) (*Set, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	f, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return Build(f)
+}
+
+// LoadFile reads a JSON configuration from the named file and builds its limiters.
+func LoadFile(name string) (*Set, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return Build(f)
+}
+
+// decode decodes a JSON configuration, rejecting unknown fields.
+func decode(data []byte) (*File, error) {
+	var f File
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("rules: %w", err)
+	}
+	return &f, nil
+}
+
+// Build validates the configuration and builds its limiters.
+// If a rule is invalid, a *RuleError is returned.
+func Build(f *File) (*Set, error) {
+	s := &Set{byName: make(map[string]*Limiter)}
+	for i, rule := range f.Rules {
+		l, err := newLimiter(rule)
+		if err != nil {
+			err.Index = i
+			return nil, err
+		}
+		if _, ok := s.byName[rule.Name]; ok {
+			return nil, &RuleError{Index: i, Name: rule.Name, Field: "name", Err: errors.New("duplicate name")}
+		}
+		s.limiters = append(s.limiters, l)
+		s.byName[rule.Name] = l
+	}
+	return s, nil
+}
+
+// Update validates the configuration and builds its limiters, keeping the
+// state of the limiters in s. Limiters whose rule has not changed are reused
+// as they are. Limiters whose rule has changed keep their buckets, which are
+// reconfigured to the new limits using the policy if the limits of their
+// window have changed, such as by a rate or the keys of a tier. Changes to
+// the routes or keys of the rule alone do not reconfigure any buckets. The
+// buckets of windows which were added to a rule start full, and those of
+// removed windows are discarded.
+//
+// The limiters in s are reconfigured in place, so s should not be used once
+// Update has succeeded. If the configuration is invalid, s is not changed.
+func (s *Set) Update(f *File, policy gorl.TokenPolicy) (*Set, error) {
+	next, err := Build(f)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, l := range next.limiters {
+		old, ok := s.byName[l.Rule.Name]
+		switch {
+		case !ok:
+			continue
+		case reflect.DeepEqual(old.Rule, l.Rule):
+			next.limiters[i] = old
+			next.byName[l.Rule.Name] = old
+		default:
+			l.migrate(old, policy)
+		}
+	}
+	return next, nil
+}
+
+// Resolve returns the limiter of the first rule which applies to the route
+// and key, and whether one was found. The key is then passed to the limiter.
+func (s *Set) Resolve(route, key string) (*Limiter, bool) {
+	for _, l := range s.limiters {
+		if match(l.Rule.Routes, route) && match(l.Rule.Keys, key) {
+			return l, true
+		}
+	}
+	return nil, false
+}
+
+// Limiter returns the limiter with the name, and whether it exists.
+func (s *Set) Limiter(name string) (*Limiter, bool) {
+	l, ok := s.byName[name]
+	return l, ok
+}
+
+// Limiters returns every limiter, in the order of the rules.
+func (s *Set) Limiters() []*Limiter {
+	return append([]*Limiter(nil), s.limiters...)
+}
+
+// Limiter enforces the limits of a rule, with a BucketManager for each window.
+// It implements gorl.KeyedLimiter.
+type Limiter struct {
+	// Rule is the rule which the limiter was built from.
+	Rule Rule
+	// Managers hold the buckets for each window of the rule, in order.
+	Managers []*gorl.BucketManager
+
+	resolvers []*resolver
+}
+
+var _ gorl.KeyedLimiter = (*Limiter)(nil)
+
+// Allow draws 1 token for the key from every window, returning whether there was one available.
+func (l *Limiter) Allow(key string) bool {
+	return l.AllowN(key, 1)
+}
+
+// AllowN draws n tokens for the key from every window, returning whether
+// there were enough tokens remaining in all of them. If not, no tokens
+// are drawn from any window.
+func (l *Limiter) AllowN(key string, n int64) bool {
+	if len(l.Managers) == 1 {
+		return l.Managers[0].AllowN(key, n)
+	}
+	return l.composite(key).AllowN(n)
+}
+
+// Remaining returns the number of tokens which can be drawn for the key from every window.
+func (l *Limiter) Remaining(key string) int64 {
+	if len(l.Managers) == 1 {
+		return l.Managers[0].Remaining(key)
+	}
+	return l.composite(key).Remaining()
+}
+
+// RetryAfter returns how long until n tokens can be drawn for the key from every window.
+func (l *Limiter) RetryAfter(key string, n int64) time.Duration {
+	if len(l.Managers) == 1 {
+		return l.Managers[0].RetryAfter(key, n)
+	}
+	return l.composite(key).RetryAfter(n)
+}
+
+// migrate moves the buckets of each window of old to l, reconfiguring them to
+// the limits of l using the policy. Windows whose limits have not changed are
+// not reconfigured, so their refills stay anchored to the same times.
+func (l *Limiter) migrate(old *Limiter, policy gorl.TokenPolicy) {
+	for window := range l.Managers {
+		if window >= len(old.Managers) {
+			break
+		}
+
+		// the old resolver is used by the old manager, so it is given the new
+		// tiers before the buckets are reconfigured to the configs it returns.
+		table := l.resolvers[window].table.Load().(*tierTable)
+		prev := old.resolvers[window].table.Swap(table).(*tierTable)
+		if !table.sameLimits(prev) {
+			// the limits were validated by Build, and errors from the store
+			// are passed to the manager's ErrorHandler.
+			old.Managers[window].Reconfigure(table.fallback, policy)
+		}
+
+		l.Managers[window] = old.Managers[window]
+		l.resolvers[window] = old.resolvers[window]
+	}
+}
+
+// composite returns the buckets for the key from every window, which are
+// always in the same order, so concurrent draws lock them consistently.
+func (l *Limiter) composite(key string) *gorl.Composite {
+	buckets := make([]*gorl.Bucket, len(l.Managers))
+	for i, bm := range l.Managers {
+		buckets[i] = bm.Get(key)
+	}
+	c := gorl.NewComposite(buckets...)
+	c.Clock = l.Managers[0].Clock
+	return c
+}
+
+// newLimiter validates the rule and builds its limiter.
+func newLimiter(rule Rule) (*Limiter, *RuleError) {
+	fail := func(field string, err error) (*Limiter, *RuleError) {
+		return nil, &RuleError{Name: rule.Name, Field: field, Err: err}
+	}
+
+	if rule.Name == "" {
+		return fail("name", errors.New("missing name"))
+	}
+	if err := validatePatterns(rule.Routes); err != nil {
+		return fail("routes", err)
+	}
+	if err := validatePatterns(rule.Keys); err != nil {
+		return fail("keys", err)
+	}
+	if len(rule.Limits) == 0 {
+		return fail("limits", errors.New("missing limits"))
+	}
+	limits, field, err := parseRates("limits", rule.Limits)
+	if err != nil {
+		return fail(field, err)
+	}
+
+	tiers := make([][]gorl.Config, len(rule.Tiers))
+	names := make(map[string]bool)
+	for i, tier := range rule.Tiers {
+		prefix := fmt.Sprintf("tiers[%d]", i)
+		switch {
+		case tier.Name == "":
+			return fail(prefix+".name", errors.New("missing name"))
+		case names[tier.Name]:
+			return fail(prefix+".name", fmt.Errorf("duplicate tier %q", tier.Name))
+		case len(tier.Limits) != len(rule.Limits):
+			return fail(prefix+".limits", fmt.Errorf("expected %d limits to match the rule, got %d", len(rule.Limits), len(tier.Limits)))
+		}
+		names[tier.Name] = true
+		if err := validatePatterns(tier.Keys); err != nil {
+			return fail(prefix+".keys", err)
+		}
+		if tiers[i], field, err = parseRates(prefix+".limits", tier.Limits); err != nil {
+			return fail(field, err)
+		}
+	}
+
+	l := &Limiter{Rule: rule}
+	for window, cfg := range limits {
+		table := &tierTable{tiers: rule.Tiers, fallback: cfg}
+		for _, configs := range tiers {
+			table.configs = append(table.configs, configs[window])
+		}
+
+		r := &resolver{}
+		r.table.Store(table)
+		bm := gorl.New(cfg.Limit, cfg.Burst, cfg.Refill)
+		bm.Resolver = r.resolve
+
+		l.Managers = append(l.Managers, bm)
+		l.resolvers = append(l.resolvers, r)
+	}
+	return l, nil
+}
+
+// resolver gives each key of a window the config of its tier. The tiers are
+// held atomically, so that they can be replaced while the manager is in use.
+type resolver struct {
+	table atomic.Value // *tierTable
+}
+
+type tierTable struct {
+	tiers    []Tier
+	configs  []gorl.Config // for each tier
+	fallback gorl.Config
+}
+
+// sameLimits returns whether every key is given the same config by both tables.
+// Tier names are not compared, since they do not affect the configs.
+func (t *tierTable) sameLimits(other *tierTable) bool {
+	if t.fallback != other.fallback || len(t.tiers) != len(other.tiers) {
+		return false
+	}
+	for i, tier := range t.tiers {
+		if t.configs[i] != other.configs[i] || !reflect.DeepEqual(tier.Keys, other.tiers[i].Keys) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolve returns the config of the first tier which the key matches,
+// or the config of the rule if it matches none.
+func (r *resolver) resolve(id string) gorl.Config {
+	table := r.table.Load().(*tierTable)
+	for i, tier := range table.tiers {
+		if match(tier.Keys, id) {
+			return table.configs[i]
+		}
+	}
+	return table.fallback
+}
+
+// parseRates parses each rate, returning the name of the field which is invalid if one is.
+func parseRates(field string, rates Rates) ([]gorl.Config, string, error) {
+	configs := make([]gorl.Config, len(rates))
+	for i, rate := range rates {
+		r, err := gorl.ParseRate(rate)
+		if err != nil {
+			return nil, fmt.Sprintf("%s[%d]", field, i), err
+		}
+		configs[i] = r.Config()
+	}
+	return configs, "", nil
+}
+
+// validatePatterns returns an error if any of the patterns is malformed.
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// match returns whether the value matches any of the patterns,
+// or true if there are no patterns.
+func match(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
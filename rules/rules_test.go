@@ -0,0 +1,127 @@
+package rules
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zytekaron/gorl"
+)
+
+const config = `{
+	"rules": [
+		{
+			"name": "api",
+			"routes": ["/api/*"],
+			"limits": ["2/s burst 3", "5 per hour"],
+			"tiers": [
+				{"name": "pro", "keys": ["pro:*"], "limits": ["20/1s", "50/1h"]}
+			]
+		},
+		{"name": "admin", "keys": ["admin"], "limits": "100/s"},
+		{"name": "default", "limits": "1/m"}
+	]
+}`
+
+func TestLoad(t *testing.T) {
+	s, err := Load(strings.NewReader(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct{ route, key, name string }{
+		{"/api/users", "alice", "api"},
+		{"/api/users", "admin", "api"},
+		{"/login", "admin", "admin"},
+		{"/login", "alice", "default"},
+	} {
+		l, ok := s.Resolve(tc.route, tc.key)
+		if !ok || l.Rule.Name != tc.name {
+			t.Errorf("expected %s %s to resolve to %q, got %v", tc.route, tc.key, tc.name, l)
+		}
+	}
+
+	api, _ := s.Limiter("api")
+	if n := len(api.Managers); n != 2 {
+		t.Fatal("expected 2 windows, got", n)
+	}
+	if !api.AllowN("alice", 3) {
+		t.Error("expected to be able to draw 3 tokens")
+	}
+	if api.Allow("alice") {
+		t.Error("expected the per-second window to deny the draw")
+	}
+	if remaining := api.Managers[1].Remaining("alice"); remaining != 2 {
+		t.Error("expected the hourly window to have 2 tokens, got", remaining)
+	}
+	if remaining := api.Remaining("pro:bob"); remaining != 20 {
+		t.Error("expected the pro tier to have 20 tokens, got", remaining)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, tc := range []struct{ config, field string }{
+		{`{"rules": [{"limits": "1/s"}]}`, "name"},
+		{`{"rules": [{"name": "a", "limits": "1/s"}, {"name": "a", "limits": "1/s"}]}`, "name"},
+		{`{"rules": [{"name": "a"}]}`, "limits"},
+		{`{"rules": [{"name": "a", "limits": ["1/s", "1/x"]}]}`, "limits[1]"},
+		{`{"rules": [{"name": "a", "routes": ["["], "limits": "1/s"}]}`, "routes"},
+		{`{"rules": [{"name": "a", "limits": "1/s", "tiers": [{"name": "b", "limits": ["1/s", "2/s"]}]}]}`, "tiers[0].limits"},
+		{`{"rules": [{"name": "a", "limits": "1/s", "tiers": [{"name": "b", "limits": "x"}]}]}`, "tiers[0].limits[0]"},
+	} {
+		_, err := Load(strings.NewReader(tc.config))
+		var ruleErr *RuleError
+		if !errors.As(err, &ruleErr) {
+			t.Errorf("expected a RuleError for %s, got %v", tc.config, err)
+			continue
+		}
+		if ruleErr.Field != tc.field {
+			t.Errorf("expected the error to point to %q, got %q", tc.field, ruleErr.Field)
+		}
+	}
+
+	if _, err := Load(strings.NewReader(`{"rules": [{"name": "a", "limit": "1/s"}]}`)); err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+}
+
+func TestSet_Update(t *testing.T) {
+	s, err := Load(strings.NewReader(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, _ := s.Limiter("api")
+	api.AllowN("alice", 2)
+	next := api.Managers[0].NextRefill("alice")
+	time.Sleep(time.Millisecond)
+
+	// changing the routes, keys and tier names does not reconfigure the
+	// buckets, while changing the hourly rate only reconfigures that window.
+	f, err := decode([]byte(strings.NewReplacer(
+		`"routes": ["/api/*"]`, `"routes": ["/v2/*"], "keys": ["*"]`,
+		`"name": "pro"`, `"name": "premium"`,
+		`"5 per hour"`, `"10 per hour"`,
+	).Replace(config)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = s.Update(f, gorl.KeepTokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l, ok := s.Resolve("/v2/users", "alice"); !ok || l.Rule.Name != "api" {
+		t.Error("expected the new routes to be used")
+	}
+	api, _ = s.Limiter("api")
+	if got := api.Managers[0].NextRefill("alice"); !got.Equal(next) {
+		t.Error("expected the unchanged window to keep its refill time, got", got.Sub(next))
+	}
+	if remaining := api.Managers[0].Remaining("alice"); remaining != 1 {
+		t.Error("expected the unchanged window to keep 1 token, got", remaining)
+	}
+	if cfg := api.Managers[1].Config("alice"); cfg.Burst != 10 {
+		t.Error("expected the hourly window to be reconfigured, got", cfg)
+	}
+}
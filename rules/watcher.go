@@ -0,0 +1,169 @@
+package rules
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zytekaron/gorl"
+)
+
+// Watcher keeps a Set up to date with a configuration file, which it polls
+// for changes. See Watch.
+type Watcher struct {
+	name   string
+	set    atomic.Value // *Set
+	errs   chan error
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// reloadMux serializes reloads, and guards last,
+	// which is the content of the configuration in use.
+	reloadMux sync.Mutex
+	last      []byte
+}
+
+// Watch loads the configuration file, then starts a goroutine which reads it
+// again at each interval and applies any changes, until ctx is done or the
+// returned Watcher is stopped. An error is returned if the file can not be
+// loaded initially.
+//
+// Changes are applied using Set.Update with gorl.ScaleTokens, so limiters
+// whose rule did not change keep their state, and the buckets of changed
+// rules keep their token level in proportion to the new burst quantity.
+// The new Set replaces the old one atomically.
+//
+// If the file can not be read or is invalid, the error is sent to Errors and
+// the last good configuration remains in use until the file is fixed. The
+// file is read again at each interval, so a file which was read while it was
+// being written is applied once it is complete, but the same error is only
+// sent once.
+//
+// An error is returned if the interval is not positive.
+func Watch(ctx context.Context, name string, interval time.Duration) (*Watcher, error) {
+	if interval <= 0 {
+		return nil, errors.New("rules: watch interval must be positive")
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	set, err := Build(f)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	w := &Watcher{
+		name:   name,
+		errs:   make(chan error, 8),
+		cancel: cancel,
+		done:   make(chan struct{}),
+		last:   data,
+	}
+	w.set.Store(set)
+
+	go func() {
+		defer close(w.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		// an error which persists is only reported once, rather than at every interval.
+		var reported string
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := w.Reload()
+				switch {
+				case err == nil:
+					reported = ""
+				case err.Error() != reported:
+					reported = err.Error()
+					w.report(err)
+				}
+			}
+		}
+	}()
+
+	return w, nil
+}
+
+// Set returns the current set of limiters.
+func (w *Watcher) Set() *Set {
+	return w.set.Load().(*Set)
+}
+
+// Resolve returns the limiter of the first rule in the current
+// configuration which applies to the route and key.
+func (w *Watcher) Resolve(route, key string) (*Limiter, bool) {
+	return w.Set().Resolve(route, key)
+}
+
+// Reload reads the configuration file and applies it if it has changed
+// since it was last applied. If the file can not be read or is invalid, the
+// error is returned and the last good configuration remains in use.
+//
+// Reload is called by the watcher at each interval, but may also be called
+// directly, such as when the process receives SIGHUP. Errors returned by
+// Reload are not sent to Errors.
+func (w *Watcher) Reload() error {
+	w.reloadMux.Lock()
+	defer w.reloadMux.Unlock()
+
+	data, err := os.ReadFile(w.name)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(data, w.last) {
+		return nil
+	}
+
+	f, err := decode(data)
+	if err != nil {
+		return err
+	}
+	set, err := w.Set().Update(f, gorl.ScaleTokens)
+	if err != nil {
+		return err
+	}
+	w.set.Store(set)
+	w.last = data
+	return nil
+}
+
+// Errors returns a channel which receives the errors of reloads made by the
+// watcher. Errors are discarded if the channel is full.
+func (w *Watcher) Errors() <-chan error {
+	return w.errs
+}
+
+// Stop stops the watcher and waits for its goroutine to exit.
+func (w *Watcher) Stop() {
+	w.cancel()
+	<-w.done
+}
+
+// Done returns a channel which is closed once the watcher has stopped.
+func (w *Watcher) Done() <-chan struct{} {
+	return w.done
+}
+
+// report sends the error to Errors, unless the channel is full.
+func (w *Watcher) report(err error) {
+	select {
+	case w.errs <- err:
+	default:
+	}
+}
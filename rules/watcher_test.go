@@ -0,0 +1,122 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, config string) {
+	if err := os.WriteFile(name, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcher_Reload(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "rules.json")
+	writeConfig(t, name, `{"rules": [
+		{"name": "a", "limits": "10/1h"},
+		{"name": "b", "limits": "10/1h"}
+	]}`)
+
+	w, err := Watch(context.Background(), name, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	a, _ := w.Set().Limiter("a")
+	a.AllowN("key", 4)
+	b, _ := w.Set().Limiter("b")
+	b.AllowN("key", 4)
+
+	// a is unchanged and keeps its limiter, b doubles its burst and keeps its level
+	writeConfig(t, name, `{"rules": [
+		{"name": "a", "limits": "10/1h"},
+		{"name": "b", "limits": "20/1h"},
+		{"name": "c", "limits": "1/1h"}
+	]}`)
+	if err := w.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if l, _ := w.Set().Limiter("a"); l != a {
+		t.Error("expected unchanged rule to keep its limiter")
+	}
+	if l, _ := w.Set().Limiter("b"); l.Remaining("key") != 12 {
+		t.Error("expected changed rule to keep its token level in proportion, got", l.Remaining("key"))
+	}
+	if _, ok := w.Set().Limiter("c"); !ok {
+		t.Error("expected new rule to be added")
+	}
+
+	// an invalid config is rejected and the last good one remains in use
+	writeConfig(t, name, `{"rules": [{"name": "a", "limits": "10/x"}]}`)
+	var ruleErr *RuleError
+	if err := w.Reload(); !errors.As(err, &ruleErr) {
+		t.Error("expected a RuleError, got", err)
+	}
+	if _, ok := w.Set().Limiter("c"); !ok {
+		t.Error("expected the last good config to remain in use")
+	}
+	if err := w.Reload(); !errors.As(err, &ruleErr) {
+		t.Error("expected an unchanged invalid config to be read again, got", err)
+	}
+}
+
+func TestWatcher_Poll(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "rules.json")
+	writeConfig(t, name, `{"rules": [{"name": "a", "limits": "10/1h"}]}`)
+
+	w, err := Watch(context.Background(), name, 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	writeConfig(t, name, `{"rules": [{"limits": "10/1h"}]}`)
+	select {
+	case err := <-w.Errors():
+		var ruleErr *RuleError
+		if !errors.As(err, &ruleErr) || ruleErr.Field != "name" {
+			t.Error("expected a RuleError for the name, got", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a reload error")
+	}
+
+	// the file is read again at each interval, but the error is only sent once.
+	select {
+	case err := <-w.Errors():
+		t.Error("expected the same error to only be sent once, got", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	writeConfig(t, name, `{"rules": [{"name": "b", "limits": "10/1h"}]}`)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if l, ok := w.Resolve("/", "key"); ok && l.Rule.Name == "b" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the fixed config to be applied")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWatchInvalid(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "rules.json")
+	writeConfig(t, name, `{"rules": [{"name": "a"}]}`)
+
+	if _, err := Watch(context.Background(), name, time.Hour); err == nil {
+		t.Error("expected an error for an invalid initial config")
+	}
+
+	writeConfig(t, name, `{"rules": [{"name": "a", "limits": "10/1h"}]}`)
+	if _, err := Watch(context.Background(), name, 0); err == nil {
+		t.Error("expected an error for a non-positive interval")
+	}
+}
@@ -0,0 +1,120 @@
+package gorl
+
+import (
+	"time"
+)
+
+// ShardedStore is a Store which spreads buckets over several MemoryStores
+// by a hash of their id, so that each shard is locked independently. This
+// reduces lock contention in managers which handle many different ids.
+type ShardedStore struct {
+	shards []*MemoryStore
+}
+
+// NewShardedStore creates a new, empty ShardedStore with
+// the provided number of shards, which must be at least 1.
+func NewShardedStore(shards int) *ShardedStore {
+	return NewShardedStoreFunc(shards, NewMemoryStore)
+}
+
+// NewShardedStoreFunc creates a new, empty ShardedStore with the provided
+// number of shards, which must be at least 1, each created by newShard.
+// This allows the shards to be configured, such as to bound each of them
+// with MaxBuckets, so that the store holds at most shards*MaxBuckets buckets.
+func NewShardedStoreFunc(shards int, newShard func() *MemoryStore) *ShardedStore {
+	if shards < 1 {
+		shards = 1
+	}
+
+	s := &ShardedStore{
+		shards: make([]*MemoryStore, shards),
+	}
+	for i := range s.shards {
+		s.shards[i] = newShard()
+	}
+	return s
+}
+
+// NewSharded creates a new BucketManager whose buckets are held by a ShardedStore.
+func NewSharded(limit, burst int64, refill time.Duration, shards int) *BucketManager {
+	return NewWithStore(limit, burst, refill, NewShardedStore(shards))
+}
+
+// Apply refills the bucket at the specified time and then performs op with n.
+func (s *ShardedStore) Apply(id string, cfg Config, t time.Time, op Op, n int64) (Result, error) {
+	return s.shard(id).Apply(id, cfg, t, op, n)
+}
+
+// Load returns the state of the bucket without refilling or creating it.
+func (s *ShardedStore) Load(id string) (State, bool, error) {
+	return s.shard(id).Load(id)
+}
+
+// Save replaces the bucket with one created from the provided state.
+func (s *ShardedStore) Save(id string, st State) error {
+	return s.shard(id).Save(id, st)
+}
+
+// Delete removes the bucket.
+func (s *ShardedStore) Delete(id string) error {
+	return s.shard(id).Delete(id)
+}
+
+// Purge removes every bucket which is fully refilled at the specified time.
+func (s *ShardedStore) Purge(t time.Time) (int, error) {
+	removed := 0
+	for _, shard := range s.shards {
+		n, _ := shard.Purge(t)
+		removed += n
+	}
+	return removed, nil
+}
+
+// Sweep removes every bucket which is fully refilled at the specified time,
+// or which has not been used for longer than idle, if idle is positive.
+func (s *ShardedStore) Sweep(t time.Time, idle time.Duration) (int, error) {
+	removed := 0
+	for _, shard := range s.shards {
+		n, _ := shard.Sweep(t, idle)
+		removed += n
+	}
+	return removed, nil
+}
+
+// Range calls fn with the state of each bucket until fn returns false.
+func (s *ShardedStore) Range(fn func(id string, st State) bool) error {
+	more := true
+	for _, shard := range s.shards {
+		shard.Range(func(id string, st State) bool {
+			more = fn(id, st)
+			return more
+		})
+		if !more {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *ShardedStore) bucket(id string, cfg Config, clock Clock) *Bucket {
+	return s.shard(id).bucket(id, cfg, clock)
+}
+
+func (s *ShardedStore) setBucket(id string, bucket *Bucket) {
+	s.shard(id).setBucket(id, bucket)
+}
+
+// shard returns the shard which holds the id, using the FNV-1a hash of the id.
+func (s *ShardedStore) shard(id string) *MemoryStore {
+	const (
+		offset = 14695981039346656037
+		prime  = 1099511628211
+	)
+
+	hash := uint64(offset)
+	for i := 0; i < len(id); i++ {
+		hash ^= uint64(id[i])
+		hash *= prime
+	}
+	return s.shards[hash%uint64(len(s.shards))]
+}
@@ -0,0 +1,78 @@
+package gorl
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShardedStore_Distribution(t *testing.T) {
+	s := NewShardedStore(8)
+	cfg := Config{Limit: 5, Burst: 20, Refill: time.Second}
+
+	for i := 0; i < 1000; i++ {
+		s.Apply(strconv.Itoa(i), cfg, time.Now(), OpCheck, 0)
+	}
+	for i, shard := range s.shards {
+		if n := len(shard.buckets); n < 50 {
+			t.Errorf("expected ids to be spread over every shard, but shard %d has %d", i, n)
+		}
+	}
+}
+
+func TestShardedStore_Bounded(t *testing.T) {
+	s := NewShardedStoreFunc(4, func() *MemoryStore {
+		shard := NewMemoryStore()
+		shard.MaxBuckets = 10
+		return shard
+	})
+	cfg := Config{Limit: 5, Burst: 20, Refill: time.Second}
+
+	for i := 0; i < 1000; i++ {
+		s.Apply(strconv.Itoa(i), cfg, time.Now(), OpDraw, 1)
+	}
+	for i, shard := range s.shards {
+		if n := shard.Len(); n != 10 {
+			t.Errorf("expected shard %d to be bounded to 10 buckets, has %d", i, n)
+		}
+	}
+}
+
+func TestNewSharded(t *testing.T) {
+	now := time.Now()
+	bm := NewSharded(5, 20, time.Second, 16)
+
+	if !bm.DrawAt(id, now, 15) {
+		t.Error("expected to be able to draw 15 tokens")
+	}
+	if bm.DrawAt(id, now, 6) {
+		t.Error("expected to NOT be able to draw 6 tokens (have 5)")
+	}
+	if bm.Get(id) != bm.Get(id) {
+		t.Error("expected Get to return the live bucket")
+	}
+}
+
+func TestBucketManager_GetOrCreateAtomic(t *testing.T) {
+	for _, bm := range []*BucketManager{New(5, 20, time.Second), NewSharded(5, 20, time.Second, 4)} {
+		const workers = 50
+
+		var wg sync.WaitGroup
+		buckets := make([]*Bucket, workers)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				buckets[i] = bm.Get(id)
+			}(i)
+		}
+		wg.Wait()
+
+		for _, b := range buckets[1:] {
+			if b != buckets[0] {
+				t.Fatal("expected concurrent first calls to return the same bucket")
+			}
+		}
+	}
+}
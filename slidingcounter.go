@@ -0,0 +1,216 @@
+package gorl
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// SlidingCounter is a thread-safe sliding window counter limiter. It counts
+// requests in the current and previous fixed windows, and estimates the number
+// of requests in the rolling window by weighting the previous window's count
+// by how much of it still overlaps the rolling window.
+//
+// This uses constant memory per key, unlike SlidingLog, at the cost of being
+// approximate: it assumes requests in the previous window were evenly spread.
+//
+// Windows are aligned to the time of the first request, and to multiples of
+// Window from then on.
+//
+// Warning: As with Bucket, the times provided to the "At" methods (in order)
+// must not chronologically descend.
+type SlidingCounter struct {
+	// Limit is the number of requests allowed in any rolling Window.
+	Limit int64
+	// Window is the duration of each fixed window, and of the rolling window.
+	Window time.Duration
+	// Clock provides the current time for the non-At methods.
+	// RealClock is used if Clock is nil.
+	Clock Clock
+
+	start time.Time // start of the current window
+	curr  int64
+	prev  int64
+	mux   sync.Mutex
+}
+
+// NewSlidingCounter creates a new SlidingCounter.
+// Use NewSlidingCounterE to validate the parameters.
+func NewSlidingCounter(limit int64, window time.Duration) *SlidingCounter {
+	return &SlidingCounter{
+		Limit:  limit,
+		Window: window,
+	}
+}
+
+// CanDraw returns whether n more requests are allowed now.
+func (c *SlidingCounter) CanDraw(n int64) bool {
+	return c.CanDrawAt(c.now(), n)
+}
+
+// CanDrawAt returns whether n more requests are allowed at the specified time.
+func (c *SlidingCounter) CanDrawAt(t time.Time, n int64) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.advance(t)
+
+	return c.estimate(t)+float64(n) <= float64(c.Limit)
+}
+
+// Draw records n requests, returning whether they were allowed. If not, nothing is recorded.
+func (c *SlidingCounter) Draw(n int64) bool {
+	return c.DrawAt(c.now(), n)
+}
+
+// DrawAt records n requests at the specified time, returning whether they
+// were allowed. If not, nothing is recorded.
+func (c *SlidingCounter) DrawAt(t time.Time, n int64) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.advance(t)
+
+	if n < 0 || c.estimate(t)+float64(n) > float64(c.Limit) {
+		return false
+	}
+	c.curr += n
+	return true
+}
+
+// Remaining returns the number of requests allowed now.
+func (c *SlidingCounter) Remaining() int64 {
+	return c.RemainingAt(c.now())
+}
+
+// RemainingAt returns the number of requests allowed at the specified time.
+func (c *SlidingCounter) RemainingAt(t time.Time) int64 {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.advance(t)
+
+	remaining := int64(math.Floor(float64(c.Limit) - c.estimate(t)))
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// NextRefill returns the start of the next fixed window.
+func (c *SlidingCounter) NextRefill() time.Time {
+	return c.NextRefillAt(c.now())
+}
+
+// NextRefillAt returns the start of the next fixed window after the specified time.
+// The estimated count also decreases gradually during the window, as the previous
+// window's requests are weighted less.
+func (c *SlidingCounter) NextRefillAt(t time.Time) time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.advance(t)
+
+	return nextAfter(c.start, t, c.Window)
+}
+
+// Reset removes every recorded request.
+func (c *SlidingCounter) Reset() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.start = time.Time{}
+	c.curr = 0
+	c.prev = 0
+}
+
+// IsReset returns whether there are no requests counted in the current or previous window.
+func (c *SlidingCounter) IsReset() bool {
+	return c.IsResetAt(c.now())
+}
+
+// IsResetAt returns whether there are no requests counted in the
+// current or previous window at the specified time.
+func (c *SlidingCounter) IsResetAt(t time.Time) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.advance(t)
+
+	return c.curr == 0 && c.prev == 0
+}
+
+// Allow records one request, returning whether it was allowed.
+func (c *SlidingCounter) Allow() bool {
+	return c.Draw(1)
+}
+
+// AllowN records n requests, returning whether they were allowed. It is the same as Draw.
+func (c *SlidingCounter) AllowN(n int64) bool {
+	return c.Draw(n)
+}
+
+// RetryAfter returns how long until n more requests will be allowed.
+func (c *SlidingCounter) RetryAfter(n int64) time.Duration {
+	return c.RetryAfterAt(c.now(), n)
+}
+
+// RetryAfterAt returns how long after the specified time until n more requests will
+// be allowed, which is zero if they are allowed now, or InfDuration if n exceeds Limit.
+func (c *SlidingCounter) RetryAfterAt(t time.Time, n int64) time.Duration {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.advance(t)
+
+	if n > c.Limit {
+		return InfDuration
+	}
+	if c.estimate(t)+float64(n) <= float64(c.Limit) {
+		return 0
+	}
+
+	// wait until the previous window's weight has decayed enough,
+	// either during this window or, if the current window is too
+	// full on its own, during the next window once it is the previous.
+	start, prev := c.start, c.prev
+	room := float64(c.Limit - n - c.curr)
+	if room < 0 {
+		start, prev = start.Add(c.Window), c.curr
+		room = float64(c.Limit - n)
+	}
+
+	elapsed := time.Duration(0)
+	if float64(prev) > room {
+		elapsed = time.Duration(math.Ceil((1 - room/float64(prev)) * float64(c.Window)))
+	}
+	return start.Add(elapsed).Sub(t)
+}
+
+// now returns the current time from the limiter's clock.
+func (c *SlidingCounter) now() time.Time {
+	return clockOrReal(c.Clock).Now()
+}
+
+// advance moves the fixed windows forward to the window containing the specified time.
+//
+// the limiter must be locked for the duration of the call.
+func (c *SlidingCounter) advance(t time.Time) {
+	if c.start.IsZero() {
+		c.start = t
+		return
+	}
+
+	delta := intervalCount(c.start, t, c.Window)
+	switch {
+	case delta == 1:
+		c.prev = c.curr
+		c.curr = 0
+	case delta > 1:
+		c.prev = 0
+		c.curr = 0
+	}
+	c.start = c.start.Add(time.Duration(delta) * c.Window)
+}
+
+// estimate returns the estimated number of requests in the rolling window ending at t.
+//
+// the limiter must be locked for the duration of the call.
+func (c *SlidingCounter) estimate(t time.Time) float64 {
+	overlap := 1 - float64(t.Sub(c.start))/float64(c.Window)
+	return float64(c.prev)*overlap + float64(c.curr)
+}
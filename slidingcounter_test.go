@@ -0,0 +1,91 @@
+package gorl
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlidingCounter_Interpolation(t *testing.T) {
+	now := time.Now()
+	c := NewSlidingCounter(10, time.Minute)
+
+	if !c.DrawAt(now, 10) {
+		t.Error("expected to be able to draw 10 in the first window")
+	}
+	if c.CanDrawAt(now.Add(59*time.Second), 1) {
+		t.Error("expected to NOT be able to draw in the same window")
+	}
+
+	// 15s into the next window: 10 * 0.75 = 7.5 estimated, so 2 remaining
+	at := now.Add(75 * time.Second)
+	if remain := c.RemainingAt(at); remain != 2 {
+		t.Error("expected remaining to be 2 a quarter into the next window, got", remain)
+	}
+	if !c.DrawAt(at, 2) {
+		t.Error("expected to be able to draw 2")
+	}
+	if c.CanDrawAt(at, 1) {
+		t.Error("expected to NOT be able to draw a third")
+	}
+
+	// halfway through: 10 * 0.5 + 2 = 7 estimated
+	if remain := c.RemainingAt(now.Add(90 * time.Second)); remain != 3 {
+		t.Error("expected remaining to be 3 halfway into the next window, got", remain)
+	}
+
+	// two windows later, nothing is counted
+	if !c.IsResetAt(now.Add(180 * time.Second)) {
+		t.Error("expected the counter to be reset after two empty windows")
+	}
+}
+
+func TestSlidingCounter_WindowAlignment(t *testing.T) {
+	now := time.Now()
+	c := NewSlidingCounter(10, time.Minute)
+	c.DrawAt(now, 1)
+
+	if next := c.NextRefillAt(now.Add(90 * time.Second)); !next.Equal(now.Add(2 * time.Minute)) {
+		t.Error("expected windows to stay aligned to the first request, got", next.Sub(now))
+	}
+}
+
+func TestSlidingCounter_RetryAfter(t *testing.T) {
+	now := time.Now()
+	c := NewSlidingCounter(10, time.Minute)
+	c.DrawAt(now, 10)
+
+	// need prev * (1-f) <= 5, so f >= 0.5 of the next window
+	if d := c.RetryAfterAt(now.Add(30*time.Second), 5); d != 60*time.Second {
+		t.Errorf("expected to wait %s for 5 requests, got %s", 60*time.Second, d)
+	}
+
+	// within the next window, the weight decays: 10 * 0.75 = 7.5, need <= 7
+	at := now.Add(75 * time.Second)
+	d := c.RetryAfterAt(at, 3)
+	if !c.CanDrawAt(at.Add(d), 3) {
+		t.Errorf("expected to be able to draw 3 after waiting %s", d)
+	}
+	if c.CanDrawAt(at.Add(d-time.Second), 3) {
+		t.Errorf("expected to NOT be able to draw 3 a second before waiting %s", d)
+	}
+
+	if d := c.RetryAfterAt(at, 11); d != InfDuration {
+		t.Error("expected InfDuration for more requests than the limit, got", d)
+	}
+}
+
+func TestSlidingCounter_Manager(t *testing.T) {
+	m := NewManager(func(string) *SlidingCounter {
+		return NewSlidingCounter(3, time.Hour)
+	})
+
+	if !m.AllowN(id, 3) {
+		t.Error("expected to be able to draw 3")
+	}
+	if m.Allow(id) {
+		t.Error("expected to NOT be able to draw a fourth")
+	}
+	if m.Purge() != 0 {
+		t.Error("expected a counter with requests to not be purged")
+	}
+}
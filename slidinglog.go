@@ -0,0 +1,186 @@
+package gorl
+
+import (
+	"sync"
+	"time"
+)
+
+// SlidingLog is a thread-safe sliding window log limiter. It records the
+// time of every request drawn in the last Window, and allows at most Limit
+// of them in any rolling Window, exactly.
+//
+// The timestamps are kept in a ring buffer with room for Limit entries, so
+// the memory used grows with Limit. SlidingCounter uses constant memory.
+//
+// Warning: As with Bucket, the times provided to the "At" methods (in order)
+// must not chronologically descend.
+type SlidingLog struct {
+	// Limit is the number of requests allowed in any rolling Window.
+	Limit int64
+	// Window is the duration of the rolling window.
+	Window time.Duration
+	// Clock provides the current time for the non-At methods.
+	// RealClock is used if Clock is nil.
+	Clock Clock
+
+	times []time.Time // ring buffer of request times, oldest at head
+	head  int
+	count int
+	mux   sync.Mutex
+}
+
+// NewSlidingLog creates a new SlidingLog. Use NewSlidingLogE to validate the
+// parameters. A limit which is not positive allows no requests.
+func NewSlidingLog(limit int64, window time.Duration) *SlidingLog {
+	size := limit
+	if size < 0 {
+		size = 0
+	}
+	return &SlidingLog{
+		Limit:  limit,
+		Window: window,
+		times:  make([]time.Time, size),
+	}
+}
+
+// CanDraw returns whether n more requests are allowed in the current window.
+func (l *SlidingLog) CanDraw(n int64) bool {
+	return l.CanDrawAt(l.now(), n)
+}
+
+// CanDrawAt returns whether n more requests are allowed in the window ending at the specified time.
+func (l *SlidingLog) CanDrawAt(t time.Time, n int64) bool {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.expire(t)
+
+	return int64(l.count)+n <= l.Limit
+}
+
+// Draw records n requests, returning whether they were allowed. If not, nothing is recorded.
+func (l *SlidingLog) Draw(n int64) bool {
+	return l.DrawAt(l.now(), n)
+}
+
+// DrawAt records n requests at the specified time, returning whether they
+// were allowed. If not, nothing is recorded.
+func (l *SlidingLog) DrawAt(t time.Time, n int64) bool {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.expire(t)
+
+	if n < 0 || int64(l.count)+n > l.Limit {
+		return false
+	}
+	for i := int64(0); i < n; i++ {
+		l.times[(l.head+l.count)%len(l.times)] = t
+		l.count++
+	}
+	return true
+}
+
+// Remaining returns the number of requests allowed in the current window.
+func (l *SlidingLog) Remaining() int64 {
+	return l.RemainingAt(l.now())
+}
+
+// RemainingAt returns the number of requests allowed in the window ending at the specified time.
+func (l *SlidingLog) RemainingAt(t time.Time) int64 {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.expire(t)
+
+	return l.Limit - int64(l.count)
+}
+
+// NextRefill returns the next time that a recorded request will leave the window.
+func (l *SlidingLog) NextRefill() time.Time {
+	return l.NextRefillAt(l.now())
+}
+
+// NextRefillAt returns the next time after the specified time that a recorded request
+// will leave the window. If no requests are recorded, this is the specified time.
+func (l *SlidingLog) NextRefillAt(t time.Time) time.Time {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.expire(t)
+
+	if l.count == 0 {
+		return t
+	}
+	return l.times[l.head].Add(l.Window)
+}
+
+// Reset removes every recorded request.
+func (l *SlidingLog) Reset() {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	l.head = 0
+	l.count = 0
+}
+
+// IsReset returns whether there are no requests recorded in the current window.
+func (l *SlidingLog) IsReset() bool {
+	return l.IsResetAt(l.now())
+}
+
+// IsResetAt returns whether there are no requests recorded in the window ending at the specified time.
+func (l *SlidingLog) IsResetAt(t time.Time) bool {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.expire(t)
+
+	return l.count == 0
+}
+
+// Allow records one request, returning whether it was allowed.
+func (l *SlidingLog) Allow() bool {
+	return l.Draw(1)
+}
+
+// AllowN records n requests, returning whether they were allowed. It is the same as Draw.
+func (l *SlidingLog) AllowN(n int64) bool {
+	return l.Draw(n)
+}
+
+// RetryAfter returns how long until n more requests will be allowed.
+func (l *SlidingLog) RetryAfter(n int64) time.Duration {
+	return l.RetryAfterAt(l.now(), n)
+}
+
+// RetryAfterAt returns how long after the specified time until n more requests will
+// be allowed, which is zero if they are allowed now, or InfDuration if n exceeds Limit.
+func (l *SlidingLog) RetryAfterAt(t time.Time, n int64) time.Duration {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.expire(t)
+
+	if n > l.Limit {
+		return InfDuration
+	}
+	excess := int64(l.count) + n - l.Limit
+	if excess <= 0 {
+		return 0
+	}
+
+	// the excess oldest requests must leave the window first.
+	last := l.times[(l.head+int(excess)-1)%len(l.times)]
+	return last.Add(l.Window).Sub(t)
+}
+
+// now returns the current time from the limiter's clock.
+func (l *SlidingLog) now() time.Time {
+	return clockOrReal(l.Clock).Now()
+}
+
+// expire removes requests which are no longer inside the window ending at the
+// specified time. A request recorded at r leaves the window at r + Window.
+//
+// the limiter must be locked for the duration of the call.
+func (l *SlidingLog) expire(t time.Time) {
+	for l.count > 0 && !l.times[l.head].Add(l.Window).After(t) {
+		l.head = (l.head + 1) % len(l.times)
+		l.count--
+	}
+}
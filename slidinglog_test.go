@@ -0,0 +1,116 @@
+package gorl
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlidingLog_Rolling(t *testing.T) {
+	now := time.Now()
+	l := NewSlidingLog(5, time.Minute)
+
+	// 3 requests at 0s, 2 at 30s
+	if !l.DrawAt(now, 3) {
+		t.Error("expected to be able to draw 3 at 0s")
+	}
+	if !l.DrawAt(now.Add(30*time.Second), 2) {
+		t.Error("expected to be able to draw 2 at 30s")
+	}
+	if l.DrawAt(now.Add(59*time.Second), 1) {
+		t.Error("expected to NOT be able to draw at 59s (5 in the last minute)")
+	}
+
+	// the first 3 leave the window at exactly 60s
+	if remain := l.RemainingAt(now.Add(60 * time.Second)); remain != 3 {
+		t.Error("expected remaining to be 3 at 60s, got", remain)
+	}
+	if !l.DrawAt(now.Add(60*time.Second), 3) {
+		t.Error("expected to be able to draw 3 at 60s")
+	}
+	if l.CanDrawAt(now.Add(89*time.Second), 1) {
+		t.Error("expected to NOT be able to draw at 89s")
+	}
+	if !l.CanDrawAt(now.Add(90*time.Second), 2) {
+		t.Error("expected to be able to draw 2 at 90s")
+	}
+}
+
+func TestSlidingLog_NoBoundaryBurst(t *testing.T) {
+	// a fixed window would allow 2*limit around a boundary; the log never
+	// allows more than limit in any rolling window, however requests are spread.
+	now := time.Now()
+	l := NewSlidingLog(10, time.Second)
+
+	var allowed []time.Time
+	for ms := 0; ms < 5000; ms += 7 {
+		at := now.Add(time.Duration(ms) * time.Millisecond)
+		if l.DrawAt(at, 1) {
+			allowed = append(allowed, at)
+		}
+	}
+	for i := range allowed {
+		inWindow := 0
+		for _, other := range allowed[i:] {
+			if other.Sub(allowed[i]) < time.Second {
+				inWindow++
+			}
+		}
+		if inWindow > 10 {
+			t.Fatalf("expected at most 10 requests in any second, got %d from %s", inWindow, allowed[i])
+		}
+	}
+}
+
+func TestSlidingLog_RetryAfter(t *testing.T) {
+	now := time.Now()
+	l := NewSlidingLog(5, time.Minute)
+	l.DrawAt(now, 2)
+	l.DrawAt(now.Add(10*time.Second), 3)
+
+	at := now.Add(20 * time.Second)
+	if d := l.RetryAfterAt(at, 2); d != 40*time.Second {
+		t.Errorf("expected to wait %s for 2 requests, got %s", 40*time.Second, d)
+	}
+	if d := l.RetryAfterAt(at, 3); d != 50*time.Second {
+		t.Errorf("expected to wait %s for 3 requests, got %s", 50*time.Second, d)
+	}
+	if d := l.RetryAfterAt(at, 6); d != InfDuration {
+		t.Error("expected InfDuration for more requests than the limit, got", d)
+	}
+	if next := l.NextRefillAt(at); !next.Equal(now.Add(time.Minute)) {
+		t.Error("expected the next refill when the oldest request leaves the window")
+	}
+}
+
+func TestSlidingLog_Reset(t *testing.T) {
+	now := time.Now()
+	l := NewSlidingLog(5, time.Minute)
+
+	if !l.IsResetAt(now) {
+		t.Error("expected a new log to be reset")
+	}
+	l.DrawAt(now, 5)
+	if l.IsResetAt(now) {
+		t.Error("expected a drawn log to not be reset")
+	}
+	l.Reset()
+	if remain := l.RemainingAt(now); remain != 5 {
+		t.Error("expected remaining to be 5 after reset, got", remain)
+	}
+}
+
+func TestSlidingLog_Manager(t *testing.T) {
+	m := NewManager(func(string) *SlidingLog {
+		return NewSlidingLog(3, time.Hour)
+	})
+
+	if !m.AllowN(id, 3) {
+		t.Error("expected to be able to draw 3")
+	}
+	if m.Allow(id) {
+		t.Error("expected to NOT be able to draw a fourth")
+	}
+	if !m.Allow("other") {
+		t.Error("expected another key to be limited separately")
+	}
+}
@@ -0,0 +1,278 @@
+package gorl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrInvalidSnapshot is returned when decoding data which
+// is not a snapshot, or is a snapshot from an unknown version.
+var ErrInvalidSnapshot = errors.New("gorl: invalid snapshot")
+
+const (
+	// bucketVersion is the version of the binary encoding of a Bucket.
+	bucketVersion = 1
+	// snapshotVersion is the version of the BucketManager snapshot format.
+	snapshotVersion = 1
+)
+
+// snapshotMagic begins every BucketManager snapshot.
+var snapshotMagic = []byte("GORL")
+
+// MarshalBinary implements encoding.BinaryMarshaler, encoding the
+// bucket's parameters along with its tokens and last update time.
+func (b *Bucket) MarshalBinary() ([]byte, error) {
+	b.mux.RLock()
+	st := b.state()
+	b.mux.RUnlock()
+
+	return encodeState(st)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler,
+// replacing the bucket's parameters, tokens and last update time.
+// It returns an error if the decoded parameters are invalid.
+func (b *Bucket) UnmarshalBinary(data []byte) error {
+	st, err := decodeState(data)
+	if err != nil {
+		return err
+	}
+
+	return b.setState(st)
+}
+
+// bucketJSON is the JSON representation of a Bucket.
+type bucketJSON struct {
+	Limit      int64     `json:"limit"`
+	Burst      int64     `json:"burst"`
+	Refill     string    `json:"refill"`
+	Tokens     int64     `json:"tokens"`
+	LastUpdate time.Time `json:"last_update"`
+}
+
+// MarshalJSON implements json.Marshaler, encoding the bucket's
+// parameters along with its tokens and last update time.
+func (b *Bucket) MarshalJSON() ([]byte, error) {
+	b.mux.RLock()
+	st := b.state()
+	b.mux.RUnlock()
+
+	return json.Marshal(bucketJSON{
+		Limit:      st.Limit,
+		Burst:      st.Burst,
+		Refill:     st.Refill.String(),
+		Tokens:     st.Tokens,
+		LastUpdate: st.LastUpdate,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler,
+// replacing the bucket's parameters, tokens and last update time.
+// It returns an error if the decoded parameters are invalid.
+func (b *Bucket) UnmarshalJSON(data []byte) error {
+	var v bucketJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	refill, err := time.ParseDuration(v.Refill)
+	if err != nil {
+		return fmt.Errorf("gorl: invalid refill: %w", err)
+	}
+
+	return b.setState(State{
+		Config: Config{
+			Limit:  v.Limit,
+			Burst:  v.Burst,
+			Refill: refill,
+		},
+		Tokens:     v.Tokens,
+		LastUpdate: v.LastUpdate,
+	})
+}
+
+// setState replaces the state of the bucket, treating it as last
+// used at its last update, as newBucketFromState does. It returns
+// an error and leaves the bucket unchanged if the parameters are invalid.
+func (b *Bucket) setState(st State) error {
+	if err := st.Validate(); err != nil {
+		return err
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.Limit = st.Limit
+	b.Burst = st.Burst
+	b.Refill = st.Refill
+	b.tokens = st.Tokens
+	b.lastUpdate = st.LastUpdate
+	b.lastAccess = st.LastUpdate
+	return nil
+}
+
+// Snapshot writes the state of every bucket to w, so that they
+// can be restored later using Restore, such as after a restart.
+//
+// Buckets which are modified during the snapshot may be written
+// with their state from before or after the modification.
+func (m *BucketManager) Snapshot(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.Write(snapshotMagic)
+	bw.WriteByte(snapshotVersion)
+
+	var err error
+	rangeErr := m.store.Range(func(id string, st State) bool {
+		var data []byte
+		if data, err = encodeState(st); err != nil {
+			return false
+		}
+		err = writeRecord(bw, []byte(id), data)
+		return err == nil
+	})
+	if rangeErr != nil {
+		return rangeErr
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Restore reads a snapshot written by Snapshot, adding each bucket
+// to the BucketManager and replacing any existing bucket with the same id.
+// Buckets which are not in the snapshot are left unchanged. If a bucket in
+// the snapshot has invalid parameters, Restore stops and returns the error
+// from Config.Validate, keeping the buckets which were restored before it.
+//
+// Restored buckets are treated as last used at the time they are restored,
+// so they are not removed by Sweep until they have been idle for IdleTTL.
+func (m *BucketManager) Restore(r io.Reader) error {
+	br := bufio.NewReader(r)
+
+	header := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return ErrInvalidSnapshot
+	}
+	if !bytes.Equal(header[:len(snapshotMagic)], snapshotMagic) || header[len(snapshotMagic)] != snapshotVersion {
+		return ErrInvalidSnapshot
+	}
+
+	for {
+		id, err := readChunk(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		data, err := readChunk(br)
+		if err != nil {
+			return ErrInvalidSnapshot
+		}
+
+		st, err := decodeState(data)
+		if err != nil {
+			return err
+		}
+		if err := st.Validate(); err != nil {
+			return err
+		}
+		if s, ok := m.store.(bucketStore); ok {
+			bucket := newBucketFromState(st, m.Clock)
+			bucket.lastAccess = m.now()
+			s.setBucket(string(id), bucket)
+			continue
+		}
+		if err := m.store.Save(string(id), st); err != nil {
+			return err
+		}
+	}
+}
+
+// encodeState encodes a bucket's state as its version,
+// followed by its parameters, tokens and last update time.
+func encodeState(st State) ([]byte, error) {
+	last, err := st.LastUpdate.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 1+4*binary.MaxVarintLen64+len(last))
+	data[0] = bucketVersion
+	n := 1
+	for _, v := range []int64{st.Limit, st.Burst, int64(st.Refill), st.Tokens} {
+		n += binary.PutVarint(data[n:], v)
+	}
+	n += copy(data[n:], last)
+	return data[:n], nil
+}
+
+// decodeState decodes a bucket's state encoded by encodeState.
+func decodeState(data []byte) (State, error) {
+	if len(data) == 0 || data[0] != bucketVersion {
+		return State{}, ErrInvalidSnapshot
+	}
+	data = data[1:]
+
+	var fields [4]int64
+	for i := range fields {
+		v, n := binary.Varint(data)
+		if n <= 0 {
+			return State{}, ErrInvalidSnapshot
+		}
+		fields[i] = v
+		data = data[n:]
+	}
+
+	var last time.Time
+	if err := last.UnmarshalBinary(data); err != nil {
+		return State{}, ErrInvalidSnapshot
+	}
+
+	return State{
+		Config: Config{
+			Limit:  fields[0],
+			Burst:  fields[1],
+			Refill: time.Duration(fields[2]),
+		},
+		Tokens:     fields[3],
+		LastUpdate: last,
+	}, nil
+}
+
+// writeRecord writes each chunk prefixed by its length.
+func writeRecord(w *bufio.Writer, chunks ...[]byte) error {
+	var size [binary.MaxVarintLen64]byte
+	for _, chunk := range chunks {
+		n := binary.PutUvarint(size[:], uint64(len(chunk)))
+		w.Write(size[:n])
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readChunk reads a chunk written by writeRecord, returning
+// io.EOF if there are no more chunks to read.
+func readChunk(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil || size > 1<<20 {
+		return nil, ErrInvalidSnapshot
+	}
+
+	chunk := make([]byte, size)
+	if _, err := io.ReadFull(r, chunk); err != nil {
+		return nil, ErrInvalidSnapshot
+	}
+	return chunk, nil
+}
@@ -0,0 +1,184 @@
+package gorl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBucket_MarshalBinary(t *testing.T) {
+	now := time.Now()
+	b := NewBucket(5, 20, time.Second)
+	b.ForceDrawAt(now, 30)
+
+	data, err := b.MarshalBinary()
+	if err != nil {
+		t.Fatal("unexpected error marshalling bucket:", err)
+	}
+
+	var restored Bucket
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal("unexpected error unmarshalling bucket:", err)
+	}
+	expectSameBucket(t, b, &restored, now)
+}
+
+func TestBucket_MarshalJSON(t *testing.T) {
+	now := time.Now()
+	b := NewBucket(5, 20, time.Second)
+	b.ForceDrawAt(now, 30)
+
+	data, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal("unexpected error marshalling bucket:", err)
+	}
+
+	restored := &Bucket{}
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatal("unexpected error unmarshalling bucket:", err)
+	}
+	expectSameBucket(t, b, restored, now)
+}
+
+func TestBucket_UnmarshalInvalid(t *testing.T) {
+	var b Bucket
+	if err := b.UnmarshalBinary([]byte{99}); err != ErrInvalidSnapshot {
+		t.Error("expected ErrInvalidSnapshot for an unknown version, got", err)
+	}
+	if err := json.Unmarshal([]byte(`{"refill":"soon"}`), &b); err == nil {
+		t.Error("expected an error for an invalid refill")
+	}
+	if err := json.Unmarshal([]byte(`{"limit":5,"burst":20,"refill":"0s"}`), &b); !errors.Is(err, ErrInvalidRefill) {
+		t.Error("expected ErrInvalidRefill for a zero refill, got", err)
+	}
+
+	data, _ := encodeState(State{Config: Config{Limit: 5, Burst: 0, Refill: time.Second}})
+	if err := b.UnmarshalBinary(data); !errors.Is(err, ErrInvalidBurst) {
+		t.Error("expected ErrInvalidBurst for a zero burst, got", err)
+	}
+}
+
+func TestBucket_UnmarshalSweep(t *testing.T) {
+	now := time.Now()
+	b := NewBucket(5, 20, time.Second)
+	b.DrawAt(now, 10)
+
+	data, err := b.MarshalBinary()
+	if err != nil {
+		t.Fatal("unexpected error marshalling bucket:", err)
+	}
+	var restored Bucket
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal("unexpected error unmarshalling bucket:", err)
+	}
+
+	bm := New(5, 20, time.Second)
+	bm.IdleTTL = time.Hour
+	bm.Set(id, &restored)
+
+	// the bucket is treated as last used when it was last updated
+	if removed := bm.SweepAt(now); removed != 0 {
+		t.Error("expected unmarshalled bucket to not be swept, removed", removed)
+	}
+	if tokens := bm.TokensAt(id, now); tokens != 10 {
+		t.Error("expected unmarshalled bucket to keep 10 tokens, got", tokens)
+	}
+}
+
+func TestBucketManager_Snapshot(t *testing.T) {
+	now := time.Now()
+	bm := New(5, 20, time.Second)
+	bm.ForceDrawAt("a", now, 30)
+	bm.DrawAt("b", now, 5)
+
+	var buf bytes.Buffer
+	if err := bm.Snapshot(&buf); err != nil {
+		t.Fatal("unexpected error taking snapshot:", err)
+	}
+
+	restored := New(5, 20, time.Second)
+	restored.DrawAt("c", now, 1)
+	if err := restored.Restore(&buf); err != nil {
+		t.Fatal("unexpected error restoring snapshot:", err)
+	}
+
+	for id, tokens := range map[string]int64{"a": -10, "b": 15, "c": 19} {
+		if got := restored.TokensAt(id, now); got != tokens {
+			t.Errorf("expected bucket %q to have %d tokens, got %d", id, tokens, got)
+		}
+	}
+
+	// refills must continue from the restored last update time
+	if got := restored.TokensAt("a", now.Add(time.Second)); got != -5 {
+		t.Error("expected restored bucket to refill to -5 tokens, got", got)
+	}
+}
+
+func TestBucketManager_RestoreSweep(t *testing.T) {
+	old := time.Now().Add(-time.Hour)
+	bm := New(1, 20, time.Hour)
+	bm.ForceDrawAt("a", old, 30)
+
+	var buf bytes.Buffer
+	if err := bm.Snapshot(&buf); err != nil {
+		t.Fatal("unexpected error taking snapshot:", err)
+	}
+
+	restored := New(1, 20, time.Hour)
+	restored.IdleTTL = time.Minute
+	if err := restored.Restore(&buf); err != nil {
+		t.Fatal("unexpected error restoring snapshot:", err)
+	}
+
+	// the bucket was last used an hour ago, but has only just been restored
+	if removed := restored.SweepAt(time.Now()); removed != 0 {
+		t.Error("expected restored bucket to not be swept, removed", removed)
+	}
+	if removed := restored.SweepAt(time.Now().Add(2 * time.Minute)); removed != 1 {
+		t.Error("expected restored bucket to be swept once idle, removed", removed)
+	}
+}
+
+func TestBucketManager_RestoreInvalid(t *testing.T) {
+	bm := New(5, 20, time.Second)
+
+	for _, data := range []string{"", "NOPE\x01", "GORL\x02", "GORL\x01\x01a\x05ab"} {
+		if err := bm.Restore(bytes.NewBufferString(data)); err != ErrInvalidSnapshot {
+			t.Errorf("expected ErrInvalidSnapshot for %q, got %v", data, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.Write(snapshotMagic)
+	buf.WriteByte(snapshotVersion)
+	data, _ := encodeState(State{Config: Config{Limit: 5, Burst: 20, Refill: 0}})
+	w := bufio.NewWriter(&buf)
+	writeRecord(w, []byte(id), data)
+	w.Flush()
+	if err := bm.Restore(&buf); !errors.Is(err, ErrInvalidRefill) {
+		t.Error("expected ErrInvalidRefill for a zero refill, got", err)
+	}
+	if n := bm.Store().(*MemoryStore).Len(); n != 0 {
+		t.Error("expected no bucket to be restored, got", n)
+	}
+}
+
+func expectSameBucket(t *testing.T, want, got *Bucket, now time.Time) {
+	t.Helper()
+	if got.Limit != want.Limit || got.Burst != want.Burst || got.Refill != want.Refill {
+		t.Errorf("mismatched parameters: expected %d/%d/%s but got %d/%d/%s",
+			want.Limit, want.Burst, want.Refill, got.Limit, got.Burst, got.Refill)
+	}
+	if got.tokens != want.tokens {
+		t.Errorf("mismatched tokens: expected %d but got %d", want.tokens, got.tokens)
+	}
+	if !got.lastUpdate.Equal(want.lastUpdate) {
+		t.Errorf("mismatched last update: expected %s but got %s", want.lastUpdate, got.lastUpdate)
+	}
+	if got.TokensAt(now.Add(time.Second)) != want.TokensAt(now.Add(time.Second)) {
+		t.Error("expected restored bucket to refill identically")
+	}
+}
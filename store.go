@@ -0,0 +1,102 @@
+package gorl
+
+import "time"
+
+// Config holds the parameters used to create a Bucket.
+type Config struct {
+	// Limit is the number of requests allowed per time unit, Refill.
+	Limit int64
+	// Burst is the number of requests allowed to be made at once.
+	Burst int64
+	// Refill is the interval at which Limit tokens are added back to
+	// the bucket, with a maximum of Burst tokens.
+	Refill time.Duration
+}
+
+// State is the complete state of a Bucket, as held by a Store.
+type State struct {
+	Config
+	// Tokens is the number of tokens in the bucket, which may be negative.
+	Tokens int64
+	// LastUpdate is the time from which refill intervals are counted.
+	LastUpdate time.Time
+}
+
+// Result is the outcome of an operation applied to a bucket by a Store.
+type Result struct {
+	// State is the state of the bucket after the operation was applied.
+	State
+	// OK reports whether the operation succeeded. Only OpCheck, OpDraw
+	// and OpReserve can fail; every other operation always succeeds.
+	OK bool
+	// Drawn is the number of tokens drawn from the bucket by the operation.
+	Drawn int64
+}
+
+// Op is an operation which a Store applies to a bucket after refilling it.
+type Op int
+
+const (
+	// OpCheck reports whether n tokens can be drawn, without drawing them.
+	OpCheck Op = iota
+	// OpDraw draws n tokens if there are enough available, and fails otherwise.
+	OpDraw
+	// OpDrawMax draws up to n tokens.
+	OpDrawMax
+	// OpForceDraw draws n tokens, overdrafting the bucket if necessary.
+	OpForceDraw
+	// OpSet sets the number of tokens in the bucket to n.
+	OpSet
+	// OpReset resets the bucket to the burst quantity, without refilling it first.
+	OpReset
+	// OpReserve draws n tokens like OpForceDraw, but fails
+	// without drawing anything if n exceeds the burst quantity.
+	OpReserve
+	// OpRefund adds n tokens back to the bucket, up to the burst quantity.
+	OpRefund
+)
+
+// Store holds the buckets of a BucketManager, which allows their state
+// to live outside of process memory and be shared by several managers.
+//
+// Each call to Apply must refill the bucket and perform the operation
+// atomically, following the same rules as the Bucket "At" methods.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Apply refills the bucket with the given id at the specified time and then
+	// performs op with n, returning the state of the bucket afterwards. If the
+	// bucket does not exist, it is first created with the burst quantity of
+	// tokens using cfg. Existing buckets keep the parameters they were created with.
+	Apply(id string, cfg Config, t time.Time, op Op, n int64) (Result, error)
+	// Load returns the state of the bucket with the given id, without
+	// refilling or creating it, and whether the bucket exists.
+	Load(id string) (State, bool, error)
+	// Save replaces the state of the bucket with the given id.
+	Save(id string, s State) error
+	// Delete removes the bucket with the given id.
+	Delete(id string) error
+	// Purge removes every bucket which is fully refilled at the
+	// specified time, returning the number of buckets removed.
+	Purge(t time.Time) (int, error)
+	// Range calls fn with the state of each bucket, without refilling
+	// them, until fn returns false. Buckets which are added or removed
+	// during the iteration may or may not be visited.
+	Range(fn func(id string, s State) bool) error
+}
+
+// Sweeper is implemented by stores which can remove idle buckets.
+// It is used by BucketManager.Sweep when available, otherwise only
+// fully refilled buckets are removed, using Purge.
+type Sweeper interface {
+	// Sweep removes every bucket which is fully refilled at the specified time,
+	// or which has not been used for longer than idle, if idle is positive.
+	// It returns the number of buckets removed.
+	Sweep(t time.Time, idle time.Duration) (int, error)
+}
+
+// bucketStore is implemented by stores which hold live Bucket instances,
+// allowing the BucketManager to hand them out directly.
+type bucketStore interface {
+	bucket(id string, cfg Config, clock Clock) *Bucket
+	setBucket(id string, bucket *Bucket)
+}
@@ -0,0 +1,65 @@
+package gorl_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zytekaron/gorl"
+	"github.com/zytekaron/gorl/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func() gorl.Store {
+		return gorl.NewMemoryStore()
+	})
+}
+
+// failingStore is a Store which fails every operation.
+type failingStore struct{}
+
+var errStore = errors.New("store unavailable")
+
+func (failingStore) Apply(string, gorl.Config, time.Time, gorl.Op, int64) (gorl.Result, error) {
+	return gorl.Result{}, errStore
+}
+func (failingStore) Load(string) (gorl.State, bool, error) { return gorl.State{}, false, errStore }
+func (failingStore) Save(string, gorl.State) error         { return errStore }
+func (failingStore) Delete(string) error                   { return errStore }
+func (failingStore) Purge(time.Time) (int, error)          { return 0, errStore }
+func (failingStore) Range(func(string, gorl.State) bool) error {
+	return errStore
+}
+
+func TestBucketManager_StoreError(t *testing.T) {
+	bm := gorl.NewWithStore(5, 20, time.Second, failingStore{})
+
+	var errs []error
+	bm.ErrorHandler = func(id string, err error) {
+		errs = append(errs, err)
+	}
+
+	if bm.Draw("a", 1) {
+		t.Error("expected draw to fail closed when the store fails")
+	}
+	if remain := bm.Remaining("a"); remain != 0 {
+		t.Error("expected no remaining tokens when the store fails, got", remain)
+	}
+	if len(errs) != 2 || !errors.Is(errs[0], errStore) {
+		t.Error("expected store errors to be passed to the handler, got", errs)
+	}
+}
+
+func TestShardedStore(t *testing.T) {
+	storetest.Run(t, func() gorl.Store {
+		return gorl.NewShardedStore(4)
+	})
+}
+
+func TestBoundedMemoryStore(t *testing.T) {
+	storetest.Run(t, func() gorl.Store {
+		s := gorl.NewMemoryStore()
+		s.MaxBuckets = 1000
+		return s
+	})
+}
@@ -0,0 +1,342 @@
+// Package storetest provides a conformance test suite for gorl.Store implementations.
+package storetest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zytekaron/gorl"
+)
+
+// epoch is the time from which every test starts. It is a whole
+// number of seconds so that stores may truncate sub-second precision.
+var epoch = time.Unix(1700000000, 0)
+
+var cfg = gorl.Config{
+	Limit:  5,
+	Burst:  20,
+	Refill: time.Second,
+}
+
+// Run runs the conformance test suite against the stores returned by newStore,
+// which must return a new, empty store each time that it is called.
+func Run(t *testing.T, newStore func() gorl.Store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s gorl.Store)
+	}{
+		{"Create", testCreate},
+		{"Draw", testDraw},
+		{"DrawMax", testDrawMax},
+		{"ForceDraw", testForceDraw},
+		{"Set", testSet},
+		{"Reset", testReset},
+		{"Reserve", testReserve},
+		{"Refund", testRefund},
+		{"Refill", testRefill},
+		{"KeepsConfig", testKeepsConfig},
+		{"LoadSave", testLoadSave},
+		{"Delete", testDelete},
+		{"Purge", testPurge},
+		{"Range", testRange},
+		{"Concurrent", testConcurrent},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore())
+		})
+	}
+}
+
+func apply(t *testing.T, s gorl.Store, id string, at time.Time, op gorl.Op, n int64) gorl.Result {
+	t.Helper()
+	res, err := s.Apply(id, cfg, at, op, n)
+	if err != nil {
+		t.Fatal("unexpected error from Apply:", err)
+	}
+	return res
+}
+
+func expectTokens(t *testing.T, res gorl.Result, tokens int64) {
+	t.Helper()
+	if res.Tokens != tokens {
+		t.Errorf("expected token count to be %d, got %d", tokens, res.Tokens)
+	}
+}
+
+func testCreate(t *testing.T, s gorl.Store) {
+	res := apply(t, s, "a", epoch, gorl.OpCheck, 0)
+	expectTokens(t, res, cfg.Burst)
+	if res.Config != cfg {
+		t.Errorf("expected config to be %+v, got %+v", cfg, res.Config)
+	}
+	if !res.LastUpdate.Equal(epoch) {
+		t.Errorf("expected last update to be %s, got %s", epoch, res.LastUpdate)
+	}
+}
+
+func testDraw(t *testing.T, s gorl.Store) {
+	res := apply(t, s, "a", epoch, gorl.OpDraw, 15)
+	if !res.OK || res.Drawn != 15 {
+		t.Error("expected to be able to draw 15 tokens")
+	}
+	expectTokens(t, res, 5)
+
+	res = apply(t, s, "a", epoch, gorl.OpDraw, 6)
+	if res.OK || res.Drawn != 0 {
+		t.Error("expected to NOT be able to draw 6 tokens (have 5)")
+	}
+	expectTokens(t, res, 5)
+
+	res = apply(t, s, "a", epoch, gorl.OpCheck, 5)
+	if !res.OK || res.Drawn != 0 {
+		t.Error("expected check for 5 tokens to succeed without drawing")
+	}
+	expectTokens(t, res, 5)
+}
+
+func testDrawMax(t *testing.T, s gorl.Store) {
+	res := apply(t, s, "a", epoch, gorl.OpDrawMax, 15)
+	if res.Drawn != 15 {
+		t.Error("expected to draw 15 tokens, drew", res.Drawn)
+	}
+	res = apply(t, s, "a", epoch, gorl.OpDrawMax, 15)
+	if res.Drawn != 5 {
+		t.Error("expected to draw the remaining 5 tokens, drew", res.Drawn)
+	}
+	expectTokens(t, res, 0)
+}
+
+func testForceDraw(t *testing.T, s gorl.Store) {
+	res := apply(t, s, "a", epoch, gorl.OpForceDraw, 50)
+	if res.Drawn != 50 {
+		t.Error("expected to draw 50 tokens, drew", res.Drawn)
+	}
+	expectTokens(t, res, -30)
+}
+
+func testSet(t *testing.T, s gorl.Store) {
+	res := apply(t, s, "a", epoch, gorl.OpSet, -7)
+	expectTokens(t, res, -7)
+}
+
+func testReset(t *testing.T, s gorl.Store) {
+	apply(t, s, "a", epoch, gorl.OpForceDraw, 50)
+
+	later := epoch.Add(500 * time.Millisecond)
+	res := apply(t, s, "a", later, gorl.OpReset, 0)
+	expectTokens(t, res, cfg.Burst)
+	if !res.LastUpdate.Equal(later) {
+		t.Errorf("expected last update to be %s, got %s", later, res.LastUpdate)
+	}
+}
+
+func testReserve(t *testing.T, s gorl.Store) {
+	apply(t, s, "a", epoch, gorl.OpDraw, 15)
+
+	res := apply(t, s, "a", epoch, gorl.OpReserve, 10)
+	if !res.OK || res.Drawn != 10 {
+		t.Error("expected to reserve 10 tokens")
+	}
+	expectTokens(t, res, -5)
+
+	res = apply(t, s, "a", epoch, gorl.OpReserve, cfg.Burst+1)
+	if res.OK || res.Drawn != 0 {
+		t.Error("expected reservation exceeding burst to fail")
+	}
+	expectTokens(t, res, -5)
+}
+
+func testRefund(t *testing.T, s gorl.Store) {
+	apply(t, s, "a", epoch, gorl.OpForceDraw, 10)
+
+	res := apply(t, s, "a", epoch, gorl.OpRefund, 4)
+	expectTokens(t, res, 14)
+
+	later := epoch.Add(500 * time.Millisecond)
+	res = apply(t, s, "a", later, gorl.OpRefund, 100)
+	expectTokens(t, res, cfg.Burst)
+	if !res.LastUpdate.Equal(later) {
+		t.Errorf("expected last update to be %s, got %s", later, res.LastUpdate)
+	}
+}
+
+func testRefill(t *testing.T, s gorl.Store) {
+	apply(t, s, "a", epoch, gorl.OpDraw, 20)
+
+	// +5 per second, with the interval anchored at the first draw
+	res := apply(t, s, "a", epoch.Add(1500*time.Millisecond), gorl.OpCheck, 0)
+	expectTokens(t, res, 5)
+	if want := epoch.Add(time.Second); !res.LastUpdate.Equal(want) {
+		t.Errorf("expected last update to be %s, got %s", want, res.LastUpdate)
+	}
+
+	res = apply(t, s, "a", epoch.Add(3*time.Second), gorl.OpCheck, 0)
+	expectTokens(t, res, 15)
+
+	// capped at burst, and re-anchored at the time of the refill
+	capped := epoch.Add(10 * time.Second)
+	res = apply(t, s, "a", capped, gorl.OpCheck, 0)
+	expectTokens(t, res, cfg.Burst)
+	if !res.LastUpdate.Equal(capped) {
+		t.Errorf("expected last update to be %s, got %s", capped, res.LastUpdate)
+	}
+}
+
+func testKeepsConfig(t *testing.T, s gorl.Store) {
+	apply(t, s, "a", epoch, gorl.OpCheck, 0)
+
+	other := gorl.Config{Limit: 1, Burst: 2, Refill: time.Minute}
+	res, err := s.Apply("a", other, epoch, gorl.OpCheck, 0)
+	if err != nil {
+		t.Fatal("unexpected error from Apply:", err)
+	}
+	if res.Config != cfg {
+		t.Errorf("expected existing bucket to keep config %+v, got %+v", cfg, res.Config)
+	}
+}
+
+func testLoadSave(t *testing.T, s gorl.Store) {
+	_, ok, err := s.Load("a")
+	if err != nil {
+		t.Fatal("unexpected error from Load:", err)
+	}
+	if ok {
+		t.Error("expected missing bucket to not be loaded")
+	}
+
+	want := gorl.State{
+		Config:     cfg,
+		Tokens:     -3,
+		LastUpdate: epoch,
+	}
+	if err := s.Save("a", want); err != nil {
+		t.Fatal("unexpected error from Save:", err)
+	}
+
+	got, ok, err := s.Load("a")
+	if err != nil {
+		t.Fatal("unexpected error from Load:", err)
+	}
+	if !ok {
+		t.Fatal("expected saved bucket to be loaded")
+	}
+	if got.Config != want.Config || got.Tokens != want.Tokens || !got.LastUpdate.Equal(want.LastUpdate) {
+		t.Errorf("expected loaded state to be %+v, got %+v", want, got)
+	}
+
+	// the saved state must be used by subsequent operations
+	res := apply(t, s, "a", epoch.Add(time.Second), gorl.OpCheck, 0)
+	expectTokens(t, res, 2)
+}
+
+func testDelete(t *testing.T, s gorl.Store) {
+	apply(t, s, "a", epoch, gorl.OpForceDraw, 10)
+	if err := s.Delete("a"); err != nil {
+		t.Fatal("unexpected error from Delete:", err)
+	}
+
+	_, ok, err := s.Load("a")
+	if err != nil {
+		t.Fatal("unexpected error from Load:", err)
+	}
+	if ok {
+		t.Error("expected deleted bucket to not be loaded")
+	}
+
+	res := apply(t, s, "a", epoch, gorl.OpCheck, 0)
+	expectTokens(t, res, cfg.Burst)
+}
+
+func testPurge(t *testing.T, s gorl.Store) {
+	apply(t, s, "full", epoch, gorl.OpCheck, 0)
+	apply(t, s, "refilled", epoch, gorl.OpDraw, 5)
+	apply(t, s, "drained", epoch, gorl.OpDraw, 20)
+
+	removed, err := s.Purge(epoch.Add(time.Second))
+	if err != nil {
+		t.Fatal("unexpected error from Purge:", err)
+	}
+	if removed != 2 {
+		t.Error("expected 2 buckets to be purged, got", removed)
+	}
+
+	for id, exists := range map[string]bool{"full": false, "refilled": false, "drained": true} {
+		_, ok, err := s.Load(id)
+		if err != nil {
+			t.Fatal("unexpected error from Load:", err)
+		}
+		if ok != exists {
+			t.Errorf("expected bucket %q to exist: %t", id, exists)
+		}
+	}
+}
+
+func testRange(t *testing.T, s gorl.Store) {
+	apply(t, s, "a", epoch, gorl.OpDraw, 1)
+	apply(t, s, "b", epoch, gorl.OpDraw, 2)
+	apply(t, s, "c", epoch, gorl.OpDraw, 3)
+
+	seen := make(map[string]int64)
+	err := s.Range(func(id string, st gorl.State) bool {
+		seen[id] = st.Tokens
+		return true
+	})
+	if err != nil {
+		t.Fatal("unexpected error from Range:", err)
+	}
+	want := map[string]int64{"a": 19, "b": 18, "c": 17}
+	if len(seen) != len(want) {
+		t.Errorf("expected %d buckets to be visited, got %d", len(want), len(seen))
+	}
+	for id, tokens := range want {
+		if seen[id] != tokens {
+			t.Errorf("expected bucket %q to have %d tokens, got %d", id, tokens, seen[id])
+		}
+	}
+
+	visited := 0
+	err = s.Range(func(string, gorl.State) bool {
+		visited++
+		return false
+	})
+	if err != nil {
+		t.Fatal("unexpected error from Range:", err)
+	}
+	if visited != 1 {
+		t.Error("expected Range to stop after fn returns false, visited", visited)
+	}
+}
+
+func testConcurrent(t *testing.T, s gorl.Store) {
+	const workers = 50
+
+	var wg sync.WaitGroup
+	results := make(chan bool, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := s.Apply("a", cfg, epoch, gorl.OpDraw, 1)
+			if err != nil {
+				t.Error("unexpected error from Apply:", err)
+				return
+			}
+			results <- res.OK
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	drawn := 0
+	for ok := range results {
+		if ok {
+			drawn++
+		}
+	}
+	if drawn != int(cfg.Burst) {
+		t.Errorf("expected exactly %d concurrent draws to succeed, got %d", cfg.Burst, drawn)
+	}
+}
@@ -0,0 +1,94 @@
+package gorl
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrInvalidLimit is returned when the limit of a bucket is not positive.
+	ErrInvalidLimit = errors.New("gorl: limit must be positive")
+	// ErrInvalidBurst is returned when the burst quantity of a bucket is not positive.
+	ErrInvalidBurst = errors.New("gorl: burst must be positive")
+	// ErrInvalidRefill is returned when the refill interval of a bucket is not positive.
+	ErrInvalidRefill = errors.New("gorl: refill interval must be positive")
+	// ErrInvalidWindow is returned when the window of a sliding limiter is not positive.
+	ErrInvalidWindow = errors.New("gorl: window must be positive")
+)
+
+// Validate returns an error if the parameters can not be used to create a
+// bucket. The error wraps ErrInvalidLimit, ErrInvalidBurst or ErrInvalidRefill,
+// so it can be checked using errors.Is.
+func (c Config) Validate() error {
+	switch {
+	case c.Limit <= 0:
+		return fmt.Errorf("%w, got %d", ErrInvalidLimit, c.Limit)
+	case c.Burst <= 0:
+		return fmt.Errorf("%w, got %d", ErrInvalidBurst, c.Burst)
+	case c.Refill <= 0:
+		return fmt.Errorf("%w, got %s", ErrInvalidRefill, c.Refill)
+	}
+	return nil
+}
+
+// NewBucketE creates a new Bucket, returning an error if the parameters are
+// invalid. See Config.Validate.
+func NewBucketE(limit, burst int64, refill time.Duration) (*Bucket, error) {
+	if err := (Config{Limit: limit, Burst: burst, Refill: refill}).Validate(); err != nil {
+		return nil, err
+	}
+	return NewBucket(limit, burst, refill), nil
+}
+
+// NewE creates a new BucketManager, returning an error if the parameters are
+// invalid. See Config.Validate.
+func NewE(limit, burst int64, refill time.Duration) (*BucketManager, error) {
+	if err := (Config{Limit: limit, Burst: burst, Refill: refill}).Validate(); err != nil {
+		return nil, err
+	}
+	return New(limit, burst, refill), nil
+}
+
+// NewGCRAE creates a new GCRA limiter, returning an error if the parameters
+// are invalid. See Config.Validate. The refill interval must also be at least
+// a nanosecond per request, so that the emission interval is not zero.
+func NewGCRAE(limit, burst int64, refill time.Duration) (*GCRA, error) {
+	if err := (Config{Limit: limit, Burst: burst, Refill: refill}).Validate(); err != nil {
+		return nil, err
+	}
+	if refill < time.Duration(limit) {
+		return nil, fmt.Errorf("%w, got %s for a limit of %d (must be at least 1ns per request)", ErrInvalidRefill, refill, limit)
+	}
+	return NewGCRA(limit, burst, refill), nil
+}
+
+// NewSlidingLogE creates a new SlidingLog, returning an error wrapping
+// ErrInvalidLimit or ErrInvalidWindow if the parameters are invalid.
+func NewSlidingLogE(limit int64, window time.Duration) (*SlidingLog, error) {
+	if err := validateWindow(limit, window); err != nil {
+		return nil, err
+	}
+	return NewSlidingLog(limit, window), nil
+}
+
+// NewSlidingCounterE creates a new SlidingCounter, returning an error wrapping
+// ErrInvalidLimit or ErrInvalidWindow if the parameters are invalid.
+func NewSlidingCounterE(limit int64, window time.Duration) (*SlidingCounter, error) {
+	if err := validateWindow(limit, window); err != nil {
+		return nil, err
+	}
+	return NewSlidingCounter(limit, window), nil
+}
+
+// validateWindow returns an error if the parameters can not be used
+// to create a sliding window limiter.
+func validateWindow(limit int64, window time.Duration) error {
+	switch {
+	case limit <= 0:
+		return fmt.Errorf("%w, got %d", ErrInvalidLimit, limit)
+	case window <= 0:
+		return fmt.Errorf("%w, got %s", ErrInvalidWindow, window)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package gorl
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  Config
+		want error
+	}{
+		{Config{5, 20, time.Second}, nil},
+		{Config{0, 20, time.Second}, ErrInvalidLimit},
+		{Config{-5, 20, time.Second}, ErrInvalidLimit},
+		{Config{5, 0, time.Second}, ErrInvalidBurst},
+		{Config{5, 20, 0}, ErrInvalidRefill},
+		{Config{5, 20, -time.Second}, ErrInvalidRefill},
+	} {
+		if err := tc.cfg.Validate(); !errors.Is(err, tc.want) {
+			t.Errorf("expected %v for %v, got %v", tc.want, tc.cfg, err)
+		}
+	}
+}
+
+func TestNewBucketE(t *testing.T) {
+	if _, err := NewBucketE(0, 0, 0); !errors.Is(err, ErrInvalidLimit) {
+		t.Error("expected ErrInvalidLimit, got", err)
+	}
+	b, err := NewBucketE(5, 20, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens := b.Tokens(); tokens != 20 {
+		t.Error("expected 20 tokens, got", tokens)
+	}
+
+	// an invalid bucket does not panic
+	b = NewBucket(0, 0, 0)
+	if tokens := b.InferTokensAt(time.Now()); tokens != 0 {
+		t.Error("expected 0 tokens, got", tokens)
+	}
+
+	b = NewBucket(0, 5, time.Second)
+	b.Draw(5)
+	if d := b.RetryAfter(1); d != InfDuration {
+		t.Error("expected a bucket which never refills to never allow a retry, got", d)
+	}
+	if r := b.Reserve(1); r.Delay() < InfDuration/2 {
+		t.Error("expected a reservation from a bucket which never refills to never be usable, got", r.Delay())
+	}
+}
+
+func TestNewGCRAE(t *testing.T) {
+	for _, tc := range []struct {
+		limit, burst int64
+		refill       time.Duration
+		want         error
+	}{
+		{5, 20, time.Second, nil},
+		{0, 20, time.Second, ErrInvalidLimit},
+		{5, 0, time.Second, ErrInvalidBurst},
+		{5, 20, 0, ErrInvalidRefill},
+		{5, 20, 4, ErrInvalidRefill},
+	} {
+		if _, err := NewGCRAE(tc.limit, tc.burst, tc.refill); !errors.Is(err, tc.want) {
+			t.Errorf("expected %v for %d/%d/%s, got %v", tc.want, tc.limit, tc.burst, tc.refill, err)
+		}
+	}
+
+	// invalid limiters do not panic
+	now := time.Now()
+	for _, g := range []*GCRA{NewGCRA(0, 5, time.Second), NewGCRA(5, 5, 0), NewGCRA(1e9, 5, 1)} {
+		g.DrawAt(now, 1)
+		g.RemainingAt(now)
+		g.RetryAfterAt(now, 5)
+		g.NextRefillAt(now)
+	}
+}
+
+func TestNewSlidingE(t *testing.T) {
+	if _, err := NewSlidingLogE(-1, time.Second); !errors.Is(err, ErrInvalidLimit) {
+		t.Error("expected ErrInvalidLimit, got", err)
+	}
+	if _, err := NewSlidingLogE(5, 0); !errors.Is(err, ErrInvalidWindow) {
+		t.Error("expected ErrInvalidWindow, got", err)
+	}
+	if _, err := NewSlidingCounterE(0, time.Second); !errors.Is(err, ErrInvalidLimit) {
+		t.Error("expected ErrInvalidLimit, got", err)
+	}
+	if _, err := NewSlidingCounterE(5, -time.Second); !errors.Is(err, ErrInvalidWindow) {
+		t.Error("expected ErrInvalidWindow, got", err)
+	}
+	if _, err := NewSlidingLogE(5, time.Second); err != nil {
+		t.Error("unexpected error:", err)
+	}
+
+	// a negative limit does not panic, and allows no requests
+	if l := NewSlidingLog(-1, time.Second); l.Draw(1) {
+		t.Error("expected a negative limit to allow no requests")
+	}
+}
+
+func TestNewE(t *testing.T) {
+	if _, err := NewE(5, 20, 0); !errors.Is(err, ErrInvalidRefill) {
+		t.Error("expected ErrInvalidRefill, got", err)
+	}
+
+	var handled error
+	bm := New(5, 0, time.Second)
+	bm.ErrorHandler = func(id string, err error) {
+		handled = err
+	}
+	if bm.Draw(id, 1) {
+		t.Error("expected draw from an invalid template to fail")
+	}
+	if !errors.Is(handled, ErrInvalidBurst) {
+		t.Error("expected ErrInvalidBurst to be handled, got", handled)
+	}
+	if n := bm.Store().(*MemoryStore).Len(); n != 0 {
+		t.Error("expected no bucket to be created, got", n)
+	}
+
+	bm.Reconfigure(Config{Limit: 5, Burst: 20, Refill: time.Second}, KeepTokens)
+	if !bm.Draw(id, 1) {
+		t.Error("expected draw to succeed once the template is valid")
+	}
+}
@@ -0,0 +1,93 @@
+package gorl
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrExceedsBurst is returned by Wait when more tokens are requested
+// than the bucket can ever hold, so the wait could never be satisfied.
+var ErrExceedsBurst = errors.New("gorl: requested tokens exceed bucket burst")
+
+// Wait blocks until n tokens can be drawn from the bucket and draws them,
+// or returns ctx.Err() if the context is done first. No tokens are drawn
+// if the wait is cancelled.
+//
+// Waiters are served in the order that they called Wait, so a waiter which
+// requests a large number of tokens is not starved by smaller ones. Calls
+// to the non-blocking draw methods do not wait in line.
+func (b *Bucket) Wait(ctx context.Context, n int64) error {
+	turn := b.enqueue()
+	defer b.dequeue(turn)
+
+	// wait until every earlier waiter has drawn its tokens or given up.
+	select {
+	case <-turn:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return waitDraw(ctx, clockOrReal(b.Clock), n, func(t time.Time) (Result, error) {
+		return b.apply(t, OpDraw, n), nil
+	})
+}
+
+// waitDraw repeatedly calls draw, which must apply OpDraw with n at the
+// provided time, sleeping until the next refill between failed attempts.
+func waitDraw(ctx context.Context, clock Clock, n int64, draw func(t time.Time) (Result, error)) error {
+	for {
+		now := clock.Now()
+
+		res, err := draw(now)
+		if err != nil {
+			return err
+		}
+		if res.OK {
+			return nil
+		}
+		if n > res.Burst {
+			return ErrExceedsBurst
+		}
+
+		timer := clock.NewTimer(nextAfter(res.LastUpdate, now, res.Refill).Sub(now))
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// enqueue adds a waiter to the back of the queue, returning a
+// channel which is closed when it reaches the front of the queue.
+func (b *Bucket) enqueue() chan struct{} {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	turn := make(chan struct{})
+	b.waiters = append(b.waiters, turn)
+	if len(b.waiters) == 1 {
+		close(turn)
+	}
+	return turn
+}
+
+// dequeue removes a waiter from the queue, and notifies
+// the next waiter if the removed waiter was at the front.
+func (b *Bucket) dequeue(turn chan struct{}) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	for i, w := range b.waiters {
+		if w != turn {
+			continue
+		}
+		b.waiters = append(b.waiters[:i], b.waiters[i+1:]...)
+		if i == 0 && len(b.waiters) > 0 {
+			close(b.waiters[0])
+		}
+		return
+	}
+}
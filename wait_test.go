@@ -0,0 +1,94 @@
+package gorl
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBucket_Wait(t *testing.T) {
+	b := NewBucket(5, 5, 20*time.Millisecond)
+
+	if err := b.Wait(context.Background(), 5); err != nil {
+		t.Fatal("expected to draw the full burst without waiting, got", err)
+	}
+
+	start := time.Now()
+	if err := b.Wait(context.Background(), 5); err != nil {
+		t.Fatal("expected to draw 5 tokens after waiting, got", err)
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Error("expected to wait for a refill, but returned after", elapsed)
+	}
+}
+
+func TestBucket_WaitCancel(t *testing.T) {
+	b := NewBucket(1, 5, time.Hour)
+	b.ForceDraw(5)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := b.Wait(ctx, 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("expected context.DeadlineExceeded, got", err)
+	}
+	if len(b.waiters) != 0 {
+		t.Error("expected the cancelled waiter to leave the queue, have", len(b.waiters))
+	}
+}
+
+func TestBucket_WaitExceedsBurst(t *testing.T) {
+	b := NewBucket(5, 5, time.Second)
+
+	err := b.Wait(context.Background(), 6)
+	if !errors.Is(err, ErrExceedsBurst) {
+		t.Error("expected ErrExceedsBurst, got", err)
+	}
+}
+
+func TestBucket_WaitOrder(t *testing.T) {
+	b := NewBucket(1, 1, 50*time.Millisecond)
+	b.ForceDraw(1)
+
+	const waiters = 5
+	order := make(chan int, waiters)
+	for i := 0; i < waiters; i++ {
+		i := i
+		go func() {
+			if err := b.Wait(context.Background(), 1); err != nil {
+				t.Error("unexpected wait error:", err)
+			}
+			order <- i
+		}()
+
+		// ensure each goroutine has joined the queue before the next one starts
+		for {
+			b.mux.RLock()
+			joined := len(b.waiters) > i
+			b.mux.RUnlock()
+			if joined {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	for i := 0; i < waiters; i++ {
+		if got := <-order; got != i {
+			t.Errorf("expected waiter %d to be served next, got %d", i, got)
+		}
+	}
+}
+
+func TestBucketManager_Wait(t *testing.T) {
+	bm := New(5, 5, 20*time.Millisecond)
+
+	if err := bm.Wait(context.Background(), id, 5); err != nil {
+		t.Fatal("expected to draw the full burst without waiting, got", err)
+	}
+	if err := bm.Wait(context.Background(), id, 5); err != nil {
+		t.Fatal("expected to draw 5 tokens after waiting, got", err)
+	}
+}